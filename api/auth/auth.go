@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Subject string `json:"subject"`
+	Role    Role   `json:"role"`
+	Method  string `json:"method"`
+}
+
+// APIKey is an entry of the API key file. Only the SHA-256 of the key is stored.
+type APIKey struct {
+	Name      string `json:"name"`
+	KeySHA256 string `json:"key_sha256"`
+	Role      Role   `json:"role"`
+}
+
+type contextKey struct{}
+
+type Authenticator struct {
+	logger    *log.CustomLogger
+	apiKeys   []APIKey
+	jwtSecret []byte
+}
+
+// New returns an Authenticator accepting the given API keys and HS256 JWTs signed with jwtSecret.
+// When neither is configured authentication is disabled and every caller is an anonymous viewer.
+func New(logger *log.CustomLogger, apiKeys []APIKey, jwtSecret string) *Authenticator {
+	return &Authenticator{
+		logger:    logger,
+		apiKeys:   apiKeys,
+		jwtSecret: []byte(jwtSecret),
+	}
+}
+
+// LoadAPIKeys reads the API key file, a JSON list of keys. An empty path returns no keys.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	if err = json.Unmarshal(content, &keys); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing API key file %v: %v", path, err.Error()))
+	}
+
+	for _, key := range keys {
+		if len(key.KeySHA256) != sha256.Size*2 || !key.Role.Valid() {
+			return nil, errors.New(fmt.Sprintf("API key %q must have a hex key_sha256 and a known role", key.Name))
+		}
+	}
+
+	return keys, nil
+}
+
+// FromContext returns the identity of the caller, set by Middleware.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
+
+// Middleware authenticates every request from the X-API-Key header or an Authorization bearer JWT and stores the
+// identity in the request context. Requests with missing or invalid credentials are rejected with 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := a.authenticate(r)
+		if err != nil {
+			a.deny(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, *identity)))
+	})
+}
+
+// Require only lets callers whose role grants the capability through.
+func (a *Authenticator) Require(c Capability, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := FromContext(r.Context())
+		if !identity.Role.Can(c) {
+			a.deny(w, r, http.StatusForbidden, fmt.Sprintf("role %q is not allowed to %v", identity.Role, c))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// plaintextSearches are the query parameters searching login records by a plaintext PII value. A caller who can run
+// them can test guessed values against the masked records, so they need CapDecrypt like reading the plaintext does.
+var plaintextSearches = []string{"ip", "device_id"}
+
+// RequireData guards listings of login records: masked records need CapViewMasked, and asking for unmasked records
+// with isEncrypted=false or searching by a plaintext IP or device ID needs CapDecrypt.
+func (a *Authenticator) RequireData(next http.HandlerFunc) http.HandlerFunc {
+	return a.Require(CapViewMasked, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if isEncrypted, err := strconv.ParseBool(query.Get("isEncrypted")); err == nil && !isEncrypted {
+			a.Require(CapDecrypt, next)(w, r)
+			return
+		}
+
+		for _, param := range plaintextSearches {
+			if query.Get(param) != "" {
+				a.Require(CapDecrypt, next)(w, r)
+				return
+			}
+		}
+
+		next(w, r)
+	})
+}
+
+// authenticate resolves the caller of the request.
+func (a *Authenticator) authenticate(r *http.Request) (*Identity, error) {
+	if len(a.apiKeys) == 0 && len(a.jwtSecret) == 0 {
+		return &Identity{Subject: "anonymous", Role: RoleViewer, Method: "none"}, nil
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		hash := hex.EncodeToString(sum[:])
+
+		for _, apiKey := range a.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(hash), []byte(strings.ToLower(apiKey.KeySHA256))) == 1 {
+				return &Identity{Subject: apiKey.Name, Role: apiKey.Role, Method: "api_key"}, nil
+			}
+		}
+
+		return nil, errors.New("invalid API key")
+	}
+
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && len(a.jwtSecret) > 0 {
+		claims, err := parseJWT(strings.TrimSpace(bearer), a.jwtSecret, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		return &Identity{Subject: claims.Subject, Role: claims.Role, Method: "jwt"}, nil
+	}
+
+	return nil, errors.New("missing credentials")
+}
+
+// deny logs the denied attempt and writes the error response.
+func (a *Authenticator) deny(w http.ResponseWriter, r *http.Request, statusCode int, reason string) {
+	identity, _ := FromContext(r.Context())
+
+	lm := log.Message{Level: "WARN", TraceId: log.TraceID(r.Context()), Method: r.Method, URI: r.URL.Path, StatusCode: statusCode,
+		ErrorMessage: fmt.Sprintf("Access denied for subject %q from %v: %v", identity.Subject, r.RemoteAddr, reason)}
+	a.logger.Log(&lm)
+
+	errResp, _ := json.Marshal(struct {
+		StatusCode int    `json:"code"`
+		Err        string `json:"message"`
+	}{StatusCode: statusCode, Err: reason})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(errResp)
+}
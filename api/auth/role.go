@@ -0,0 +1,48 @@
+package auth
+
+type Role string
+
+const (
+	RoleViewer    Role = "viewer"
+	RoleAnalyst   Role = "analyst"
+	RolePIIReader Role = "pii-reader"
+	RoleAdmin     Role = "admin"
+)
+
+type Capability string
+
+const (
+	// CapViewMasked allows listing login records with their PII masked.
+	CapViewMasked Capability = "view-masked"
+	// CapAggregates allows reading statistics and reports.
+	CapAggregates Capability = "aggregates"
+	// CapDecrypt allows reading decrypted and detokenized PII.
+	CapDecrypt Capability = "decrypt"
+	// CapAdmin allows erasing users and searching the audit log.
+	CapAdmin Capability = "admin"
+)
+
+// capabilities lists what each role may do, every role includes the capabilities of the roles above it.
+var capabilities = map[Role][]Capability{
+	RoleViewer:    {CapViewMasked},
+	RoleAnalyst:   {CapViewMasked, CapAggregates},
+	RolePIIReader: {CapViewMasked, CapAggregates, CapDecrypt},
+	RoleAdmin:     {CapViewMasked, CapAggregates, CapDecrypt, CapAdmin},
+}
+
+// Valid reports whether the role is known.
+func (r Role) Valid() bool {
+	_, ok := capabilities[r]
+	return ok
+}
+
+// Can reports whether the role grants the capability.
+func (r Role) Can(c Capability) bool {
+	for _, granted := range capabilities[r] {
+		if granted == c {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/api/auth"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/api/store"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type auditHandler struct {
+	auditStore store.Audit
+}
+
+// NewAudit returns a handler searching the PII access audit log.
+func NewAudit(auditStore store.Audit) *auditHandler {
+	return &auditHandler{
+		auditStore: auditStore,
+	}
+}
+
+// Search serves the audit entries matching subject, user_id, request_id, from and to, most recent first.
+func (ah auditHandler) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	errs := fieldErrors{}
+
+	filter := model.AuditFilter{Limit: 100, Subject: query.Get("subject"), UserID: query.Get("user_id"), RequestID: query.Get("request_id")}
+
+	if limit := query.Get("limit"); limit != "" {
+		if n, err := strconv.Atoi(limit); err != nil || n < 1 || n > maxLimit {
+			errs["limit"] = fmt.Sprintf("must be an integer between 1 and %d", maxLimit)
+		} else {
+			filter.Limit = n
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		if c, err := model.DecodeCursor(cursor); err != nil {
+			errs["cursor"] = "is invalid"
+		} else {
+			filter.Cursor = c
+		}
+	}
+
+	filter.From = parseTime(query, "from", errs)
+	filter.To = parseTime(query, "to", errs)
+
+	if len(errs) > 0 {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "invalid query params.", Fields: errs})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	resp, err := ah.auditStore.Search(r.Context(), &filter)
+	if err != nil {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 500, Err: err.Error()})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	respJson, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(respJson)
+}
+
+// audit records that the request revealed the PII of userIDs. The response must not be written if it fails.
+// The request ID is the trace ID the logging middleware returns in X-Request-ID.
+func (lh loginHandler) audit(r *http.Request, userIDs []string) error {
+	identity, _ := auth.FromContext(r.Context())
+
+	return lh.auditStore.Record(r.Context(), &model.AuditEntry{
+		RequestID:  log.TraceID(r.Context()),
+		Subject:    identity.Subject,
+		Role:       string(identity.Role),
+		AuthMethod: identity.Method,
+		Endpoint:   r.Method + " " + r.URL.Path,
+		Reason:     strings.TrimSpace(r.URL.Query().Get("reason")),
+		Filters:    r.URL.Query(),
+		UserIDs:    userIDs,
+	})
+}
+
+// revealedUserIDs returns the distinct user IDs of the records and the extra ones, sorted.
+func revealedUserIDs(records []model.Response, extra ...string) []string {
+	seen := make(map[string]bool)
+	userIDs := []string{}
+
+	for _, record := range records {
+		if record.UserID != nil {
+			extra = append(extra, *record.UserID)
+		}
+	}
+
+	for _, userID := range extra {
+		if userID == "" || userID == model.ErasedMarker || seen[userID] {
+			continue
+		}
+
+		seen[userID] = true
+		userIDs = append(userIDs, userID)
+	}
+
+	sort.Strings(userIDs)
+
+	return userIDs
+}
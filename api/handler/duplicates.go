@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"net/http"
+	"strconv"
+)
+
+// Duplicates serves clusters of logins sharing a masked IP and masked device ID, largest clusters first.
+func (lh loginHandler) Duplicates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	common, errs := parseFilter(query)
+	common.Limit = parseLimit(query, errs)
+	filter := model.DuplicateFilter{Filter: common, MinSize: 2}
+
+	if minSize := query.Get("min_size"); minSize != "" {
+		if n, err := strconv.Atoi(minSize); err != nil || n < 2 {
+			errs["min_size"] = "must be an integer of at least 2"
+		} else {
+			filter.MinSize = n
+		}
+	}
+
+	// multi_user keeps only clusters shared by more than one user.
+	filter.MultiUser = parseBool(query, "multi_user", errs)
+	filter.IncludeMembers = parseBool(query, "include_members", errs)
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		if c, err := model.DecodeClusterCursor(cursor); err != nil {
+			errs["cursor"] = "is invalid"
+		} else {
+			filter.ClusterCursor = c
+		}
+	}
+
+	if len(errs) > 0 {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "invalid query params.", Fields: errs})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	filter.Detokenize = !filter.IsEncrypted
+
+	resp, err := lh.loginStore.Duplicates(r.Context(), &filter)
+	if err != nil {
+		statusCode := storeErrorStatus(err)
+		errResp, _ := json.Marshal(responseErr{StatusCode: statusCode, Err: err.Error()})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	if !filter.IsEncrypted {
+		if err = lh.audit(r, clusterUserIDs(resp.Items)); err != nil {
+			errResp, _ := json.Marshal(responseErr{StatusCode: 500, Err: err.Error()})
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write(errResp)
+			return
+		}
+	}
+
+	respJson, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(respJson)
+}
+
+// clusterUserIDs returns the users sharing the clusters and owning their members.
+func clusterUserIDs(clusters []model.DuplicateCluster) []string {
+	var members []model.Response
+	var userIDs []string
+
+	for _, cluster := range clusters {
+		members = append(members, cluster.Members...)
+		userIDs = append(userIDs, cluster.UserIDs...)
+	}
+
+	return revealedUserIDs(members, userIDs...)
+}
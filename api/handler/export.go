@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportFormats are the accepted export formats and their content type.
+var exportFormats = map[string]string{
+	"csv":     "text/csv",
+	"ndjson":  "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// exportRow is an exported login, its fields are the columns of every format.
+type exportRow struct {
+	UserID      *string   `json:"user_id" parquet:"user_id"`
+	DeviceType  *string   `json:"device_type" parquet:"device_type"`
+	IP          *string   `json:"ip" parquet:"ip"`
+	DeviceID    *string   `json:"device_id" parquet:"device_id"`
+	Locale      string    `json:"locale" parquet:"locale"`
+	AppVersion  string    `json:"app_version" parquet:"app_version"`
+	IPPseudonym *string   `json:"ip_pseudonym" parquet:"ip_pseudonym"`
+	CreatedDate time.Time `json:"created_date" parquet:"created_date,timestamp(millisecond)"`
+	Shredded    bool      `json:"shredded" parquet:"shredded"`
+}
+
+// Export streams every login matching the filters as csv, ndjson or parquet, most recent first. The status is sent
+// before the first row, so an error while streaming is reported in the X-Export-Error trailer.
+func (lh loginHandler) Export(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, errs := parseFilter(query)
+
+	format := query.Get("format")
+	if _, ok := exportFormats[format]; !ok {
+		errs["format"] = "must be one of csv, ndjson or parquet"
+	}
+
+	if len(errs) > 0 {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "invalid query params.", Fields: errs})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	filter.Detokenize = !filter.IsEncrypted
+
+	var out exportWriter
+	rc := http.NewResponseController(w)
+
+	// PII is only streamed once the access is in the audit log.
+	begin := func(userIDs []string) error {
+		if !filter.IsEncrypted {
+			if err := lh.audit(r, revealedUserIDs(nil, userIDs...)); err != nil {
+				return err
+			}
+		}
+
+		w.Header().Set("Content-Type", exportFormats[format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"logins.%s\"", format))
+		w.Header().Set("Trailer", "X-Export-Error")
+		w.WriteHeader(200)
+
+		var err error
+		out, err = newExportWriter(format, w)
+
+		return err
+	}
+
+	write := func(records []model.Response) error {
+		rows := make([]exportRow, len(records))
+		for i, record := range records {
+			rows[i] = exportRow{UserID: record.UserID, DeviceType: record.DeviceType, IP: record.IP, DeviceID: record.DeviceID, Locale: record.Locale,
+				AppVersion: record.AppVersion, IPPseudonym: record.IPPseudonym, CreatedDate: record.CreatedDate, Shredded: record.Shredded}
+		}
+
+		if err := out.Write(rows); err != nil {
+			return err
+		}
+
+		_ = rc.Flush()
+
+		return nil
+	}
+
+	err := lh.loginStore.Export(r.Context(), &filter, begin, write)
+
+	// Nothing was sent yet, the error can still be reported with a status.
+	if out == nil {
+		statusCode := storeErrorStatus(err)
+
+		errResp, _ := json.Marshal(responseErr{StatusCode: statusCode, Err: err.Error()})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	if err == nil {
+		err = out.Close()
+	}
+
+	if err != nil {
+		w.Header().Set("X-Export-Error", err.Error())
+	}
+}
+
+// exportWriter encodes exported rows to the response. Every Write is passed on to the response right away, except
+// for parquet which writes a row group per Write.
+type exportWriter interface {
+	Write(rows []exportRow) error
+	Close() error
+}
+
+func newExportWriter(format string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "csv":
+		out := &csvExport{w: csv.NewWriter(w)}
+		return out, out.header()
+	case "parquet":
+		return &parquetExport{w: parquet.NewGenericWriter[exportRow](w)}, nil
+	default:
+		return &ndjsonExport{enc: json.NewEncoder(w)}, nil
+	}
+}
+
+type csvExport struct {
+	w *csv.Writer
+}
+
+func (c *csvExport) header() error {
+	return c.w.Write([]string{"user_id", "device_type", "ip", "device_id", "locale", "app_version", "ip_pseudonym", "created_date", "shredded"})
+}
+
+func (c *csvExport) Write(rows []exportRow) error {
+	for _, row := range rows {
+		err := c.w.Write([]string{deref(row.UserID), deref(row.DeviceType), deref(row.IP), deref(row.DeviceID), row.Locale, row.AppVersion,
+			deref(row.IPPseudonym), row.CreatedDate.Format(time.RFC3339), strconv.FormatBool(row.Shredded)})
+		if err != nil {
+			return err
+		}
+	}
+
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+func (c *csvExport) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonExport struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonExport) Write(rows []exportRow) error {
+	for _, row := range rows {
+		if err := n.enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *ndjsonExport) Close() error {
+	return nil
+}
+
+type parquetExport struct {
+	w *parquet.GenericWriter[exportRow]
+}
+
+// Write buffers the rows and flushes them as one row group, so at most one batch is held in memory.
+func (p *parquetExport) Write(rows []exportRow) error {
+	if _, err := p.w.Write(rows); err != nil {
+		return err
+	}
+
+	return p.w.Flush()
+}
+
+// Close writes the parquet footer.
+func (p *parquetExport) Close() error {
+	return p.w.Close()
+}
+
+// deref returns the value of an optional column, or an empty string.
+func deref(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
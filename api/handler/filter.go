@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLimit caps the number of records returned in one page.
+const maxLimit = 1000
+
+// maxReasonLength caps the reason given for reading PII.
+const maxReasonLength = 512
+
+// fieldErrors collects validation errors keyed by query parameter.
+type fieldErrors map[string]string
+
+// parseFilter validates the query parameters shared by the listings and the export and turns them into a filter. The
+// page size and cursor are left to the listing, since every listing pages in its own order.
+func parseFilter(query url.Values) (model.Filter, fieldErrors) {
+	var filter model.Filter
+	errs := fieldErrors{}
+
+	if isEncrypted := query.Get("isEncrypted"); isEncrypted == "" {
+		errs["isEncrypted"] = "is required"
+	} else if b, err := strconv.ParseBool(isEncrypted); err != nil {
+		errs["isEncrypted"] = "must be true or false"
+	} else {
+		filter.IsEncrypted = b
+	}
+
+	// Reading PII must be justified, the reason is kept in the audit log.
+	if _, invalid := errs["isEncrypted"]; !invalid && !filter.IsEncrypted {
+		if reason := strings.TrimSpace(query.Get("reason")); reason == "" {
+			errs["reason"] = "is required when isEncrypted is false"
+		} else if len(reason) > maxReasonLength {
+			errs["reason"] = fmt.Sprintf("must be at most %d characters", maxReasonLength)
+		}
+	}
+
+	filter.GroupDuplicates = parseBool(query, "groupDuplicates", errs)
+
+	// ip_prefix is a CIDR over pseudonymous IPs, e.g. the /24 a pseudonym belongs to.
+	if ipPrefix := query.Get("ip_prefix"); ipPrefix != "" {
+		if _, _, err := net.ParseCIDR(ipPrefix); err != nil {
+			errs["ip_prefix"] = "must be a CIDR, e.g. 10.1.2.0/24"
+		} else {
+			filter.IPPrefix = ipPrefix
+		}
+	}
+
+	// Exact matches, plaintext values are masked before matching so the table is never decrypted.
+	exact := []struct {
+		param  string
+		maxLen int
+		dest   *string
+	}{
+		{"ip", 64, &filter.IP},
+		{"device_id", 128, &filter.DeviceID},
+		{"user_id", 128, &filter.UserID},
+		{"device_type", 32, &filter.DeviceType},
+		{"locale", 32, &filter.Locale},
+		{"app_version", 32, &filter.AppVersion},
+	}
+
+	for _, e := range exact {
+		value := query.Get(e.param)
+		if len(value) > e.maxLen {
+			errs[e.param] = fmt.Sprintf("must be at most %d characters", e.maxLen)
+			continue
+		}
+
+		*e.dest = value
+	}
+
+	filter.AppVersionMin = parseVersion(query, "app_version_min", errs)
+	filter.AppVersionMax = parseVersion(query, "app_version_max", errs)
+	if filter.AppVersionMin != nil && filter.AppVersionMax != nil && filter.AppVersionMin.Compare(*filter.AppVersionMax) > 0 {
+		errs["app_version_max"] = "must not be lower than app_version_min"
+	}
+
+	filter.From = parseTime(query, "from", errs)
+	filter.To = parseTime(query, "to", errs)
+	if filter.From != nil && filter.To != nil && filter.From.After(*filter.To) {
+		errs["to"] = "must not be before from"
+	}
+
+	return filter, errs
+}
+
+// parseLimit parses the required page size of a listing.
+func parseLimit(query url.Values, errs fieldErrors) int {
+	limit := query.Get("limit")
+	if limit == "" {
+		errs["limit"] = "is required"
+		return 0
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > maxLimit {
+		errs["limit"] = fmt.Sprintf("must be an integer between 1 and %d", maxLimit)
+		return 0
+	}
+
+	return n
+}
+
+// parseBool parses an optional boolean query parameter.
+func parseBool(query url.Values, param string, errs fieldErrors) bool {
+	value := query.Get(param)
+	if value == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		errs[param] = "must be true or false"
+	}
+
+	return b
+}
+
+// parseVersion parses an optional semver query parameter.
+func parseVersion(query url.Values, param string, errs fieldErrors) *model.Version {
+	value := query.Get(param)
+	if value == "" {
+		return nil
+	}
+
+	v, err := model.ParseVersion(value)
+	if err != nil {
+		errs[param] = "must be a version such as 2.3.0"
+		return nil
+	}
+
+	return &v
+}
+
+// parseTime parses an optional RFC 3339 timestamp or YYYY-MM-DD date query parameter.
+func parseTime(query url.Values, param string, errs fieldErrors) *time.Time {
+	value := query.Get(param)
+	if value == "" {
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+
+	errs[param] = "must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+
+	return nil
+}
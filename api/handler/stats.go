@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/api/store"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// bucketSizes are the accepted bucket sizes and their length.
+var bucketSizes = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// maxBuckets caps the number of buckets in one time range.
+const maxBuckets = 10000
+
+type statsHandler struct {
+	statsStore          store.Stats
+	minSupportedVersion *model.Version
+}
+
+// NewStats returns a handler serving aggregates, minSupportedVersion is the default minimum supported app version and may be nil.
+func NewStats(statsStore store.Stats, minSupportedVersion *model.Version) *statsHandler {
+	return &statsHandler{
+		statsStore:          statsStore,
+		minSupportedVersion: minSupportedVersion,
+	}
+}
+
+// Logins serves the login volume per time bucket. The range defaults to the last 7 days and the bucket to an hour.
+func (sh statsHandler) Logins(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	errs := fieldErrors{}
+
+	filter := model.StatsFilter{Bucket: "hour", GroupBy: query.Get("group_by")}
+
+	if bucket := query.Get("bucket"); bucket != "" {
+		if _, ok := bucketSizes[bucket]; !ok {
+			errs["bucket"] = "must be one of minute, hour or day"
+		} else {
+			filter.Bucket = bucket
+		}
+	}
+
+	if _, ok := store.StatsGroupColumns[filter.GroupBy]; filter.GroupBy != "" && !ok {
+		errs["group_by"] = "must be one of device_type, locale or app_version"
+	}
+
+	parseRange(query, &filter, errs)
+
+	if len(errs) == 0 && filter.To.Sub(filter.From)/bucketSizes[filter.Bucket] > maxBuckets {
+		errs["bucket"] = fmt.Sprintf("the range must not span more than %d buckets", maxBuckets)
+	}
+
+	if len(errs) > 0 {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "invalid query params.", Fields: errs})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	resp, err := sh.statsStore.Logins(r.Context(), &filter)
+	if err != nil {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 500, Err: err.Error()})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	respJson, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(respJson)
+}
+
+// AppVersions serves the adoption of app versions per device type. The range defaults to the last 7 days and the
+// minimum supported version to the configured one.
+func (sh statsHandler) AppVersions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	errs := fieldErrors{}
+
+	var filter model.StatsFilter
+	parseRange(query, &filter, errs)
+
+	minSupported := sh.minSupportedVersion
+	if v := parseVersion(query, "min_version", errs); v != nil {
+		minSupported = v
+	}
+
+	if len(errs) > 0 {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "invalid query params.", Fields: errs})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	resp, err := sh.statsStore.AppVersions(r.Context(), &filter, minSupported)
+	if err != nil {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 500, Err: err.Error()})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	respJson, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(respJson)
+}
+
+// parseRange parses the from and to query parameters of an aggregate, the range defaults to the last 7 days.
+func parseRange(query url.Values, filter *model.StatsFilter, errs fieldErrors) {
+	filter.To = time.Now().UTC()
+	if to := parseTime(query, "to", errs); to != nil {
+		filter.To = *to
+	}
+
+	filter.From = filter.To.Add(-7 * 24 * time.Hour)
+	if from := parseTime(query, "from", errs); from != nil {
+		filter.From = *from
+	}
+
+	if !filter.From.Before(filter.To) {
+		errs["to"] = "must be after from"
+	}
+}
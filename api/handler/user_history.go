@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"net/http"
+)
+
+// UserLogins serves the login timeline of the user in the path, most recent first, with a summary of the time window.
+func (lh loginHandler) UserLogins(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, errs := parseFilter(query)
+	filter.Limit = parseLimit(query, errs)
+	filter.UserID = mux.Vars(r)["user_id"]
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		if c, err := model.DecodeCursor(cursor); err != nil {
+			errs["cursor"] = "is invalid"
+		} else {
+			filter.Cursor = c
+		}
+	}
+
+	if len(errs) > 0 {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "invalid query params.", Fields: errs})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	filter.Detokenize = !filter.IsEncrypted
+
+	resp, err := lh.loginStore.UserLogins(r.Context(), &filter)
+	if err != nil {
+		statusCode := storeErrorStatus(err)
+		errResp, _ := json.Marshal(responseErr{StatusCode: statusCode, Err: err.Error()})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	if !filter.IsEncrypted {
+		if err = lh.audit(r, revealedUserIDs(resp.Logins.Items, resp.UserID)); err != nil {
+			errResp, _ := json.Marshal(responseErr{StatusCode: 500, Err: err.Error()})
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write(errResp)
+			return
+		}
+	}
+
+	respJson, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(respJson)
+}
@@ -2,42 +2,58 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/api/store"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
 	"net/http"
-	"strconv"
 )
 
 type loginHandler struct {
 	loginStore store.Login
+	auditStore store.Audit
 }
 
-func New(loginStore store.Login) *loginHandler {
+// New returns a handler serving login data. Requests reaching it with isEncrypted=false were already authorized to
+// decrypt, so tokenized fields are swapped back through the vault as well, and every such request is recorded in
+// the audit log before any PII is returned.
+func New(loginStore store.Login, auditStore store.Audit) *loginHandler {
 	return &loginHandler{
 		loginStore: loginStore,
+		auditStore: auditStore,
 	}
 }
 
 type responseErr struct {
-	StatusCode int    `json:"code"`
-	Err        string `json:"message"`
+	StatusCode int         `json:"code"`
+	Err        string      `json:"message"`
+	Fields     fieldErrors `json:"errors,omitempty"`
 }
 
-func (lh loginHandler) Get(w http.ResponseWriter, r *http.Request) {
-	var filter model.Filter
+// storeErrorStatus returns the status of a store error: 400 when the filter can not be applied, 500 otherwise.
+func storeErrorStatus(err error) int {
+	if errors.As(err, &store.FilterError{}) {
+		return http.StatusBadRequest
+	}
 
-	limit := r.URL.Query().Get("limit")
-	page := r.URL.Query().Get("page")
-	isEncrypted := r.URL.Query().Get("isEncrypted")
-	groupDuplicates := r.URL.Query().Get("groupDuplicates")
+	return http.StatusInternalServerError
+}
 
-	if groupDuplicates != "" {
-		groupDuplicatesConv, _ := strconv.ParseBool(groupDuplicates)
-		filter.GroupDuplicates = groupDuplicatesConv
+func (lh loginHandler) Get(w http.ResponseWriter, r *http.Request) {
+	filter, errs := parseFilter(r.URL.Query())
+	filter.Limit = parseLimit(r.URL.Query(), errs)
+
+	// cursor is the next_cursor of the previous page, without it the listing starts at the most recent record.
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		if c, err := model.DecodeCursor(cursor); err != nil {
+			errs["cursor"] = "is invalid"
+		} else {
+			filter.Cursor = c
+		}
 	}
 
-	if limit == "" || page == "" || isEncrypted == "" {
-		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "query params limit or page or isEncrypted is missing."})
+	if len(errs) > 0 {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: "invalid query params.", Fields: errs})
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -45,26 +61,57 @@ func (lh loginHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	limitConv, _ := strconv.Atoi(limit)
-	pageConv, _ := strconv.Atoi(page)
-	isEncryptedConv, _ := strconv.ParseBool(isEncrypted)
-
-	filter.Limit = limitConv
-	filter.Page = pageConv
-	filter.IsEncrypted = isEncryptedConv
+	filter.Detokenize = !filter.IsEncrypted
 
-	resp, err := lh.loginStore.Get(&filter)
+	resp, err := lh.loginStore.Get(r.Context(), &filter)
 	if err != nil {
-		errResp, _ := json.Marshal(responseErr{StatusCode: 400, Err: err.Error()})
+		statusCode := storeErrorStatus(err)
+		errResp, _ := json.Marshal(responseErr{StatusCode: statusCode, Err: err.Error()})
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(statusCode)
 		_, _ = w.Write(errResp)
 		return
 	}
 
+	if !filter.IsEncrypted {
+		if err = lh.audit(r, revealedUserIDs(resp.Items)); err != nil {
+			errResp, _ := json.Marshal(responseErr{StatusCode: 500, Err: err.Error()})
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write(errResp)
+			return
+		}
+	}
+
 	respJson, _ := json.Marshal(resp)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	_, _ = w.Write(respJson)
 }
+
+type eraseResponse struct {
+	UserID          string `json:"user_id"`
+	ShreddedRecords int64  `json:"shredded_records"`
+}
+
+// Erase destroys the data key of the user in the path so that their PII can no longer be recovered.
+func (lh loginHandler) Erase(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+
+	shredded, err := lh.loginStore.Erase(r.Context(), userID)
+	if err != nil {
+		errResp, _ := json.Marshal(responseErr{StatusCode: 500, Err: err.Error()})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(errResp)
+		return
+	}
+
+	respJson, _ := json.Marshal(eraseResponse{UserID: userID, ShreddedRecords: shredded})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(respJson)
+}
@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/api/auth"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/api/handler"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/api/middleware"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/api/store"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/database"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/health"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/internal/metrics"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/keystore"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/migrations"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/vault"
 	"net/http"
 	"os"
+	"time"
 )
 
 // init function runs before the main function. It loads environment variables from a .env file.
@@ -21,8 +33,6 @@ func init() {
 	}
 }
 func main() {
-	encryptionKey := os.Getenv("ENCRYPTION_SECRET")
-
 	// Initialize Logger
 	logger, err := log.NewCustomLogger("../../app_logs")
 	if err != nil {
@@ -33,10 +43,58 @@ func main() {
 	lm := log.Message{Level: "INFO", Msg: "Logger initialized successfully"}
 	logger.Log(&lm)
 
+	// Settings come from the config file, the environment and the flags, every invalid setting is reported at once.
+	cfg, _, err := config.Load(config.API, os.Args[1:])
+	if err == flag.ErrHelp {
+		fmt.Fprint(os.Stderr, "usage: dataops-takehome-server [flags]\n\nflags, each overriding the config file and the environment:\n"+config.Usage())
+		return
+	}
+
+	if err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+		logger.Log(&lm)
+
+		os.Exit(2)
+	}
+
+	encryptionKey := cfg.Masking.EncryptionSecret
+
+	// Load the masking policy the ETL used, so the API knows how to reverse or display each field.
+	maskingPolicy, err := model.LoadMaskingPolicy(cfg.Masking.PolicyFile)
+	if err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Loading masking policy failed with error %v", err.Error())}
+		logger.Log(&lm)
+
+		return
+	}
+
+	// The minimum supported app version is optional, versions below it are flagged in the app version report. It was
+	// validated when the config was loaded.
+	var minSupportedVersion *model.Version
+	if cfg.API.MinSupportedAppVersion != "" {
+		v, _ := model.ParseVersion(cfg.API.MinSupportedAppVersion)
+		minSupportedVersion = &v
+	}
+
+	// API keys and JWTs authenticate callers, their role decides what they may see.
+	apiKeys, err := auth.LoadAPIKeys(cfg.API.APIKeysFile)
+	if err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Loading API keys failed with error %v", err.Error())}
+		logger.Log(&lm)
+
+		return
+	}
+
+	if len(apiKeys) == 0 && cfg.API.JWTSecret == "" {
+		lm = log.Message{Level: "WARN", Msg: "No API keys or JWT secret configured, every caller is an anonymous viewer"}
+		logger.Log(&lm)
+	}
+
+	authenticator := auth.New(logger, apiKeys, cfg.API.JWTSecret)
+
 	// Initialize a new database connection.
-	db := database.New(logger)
+	db := database.New(logger, cfg.DB)
 	dbConn, err := db.Open()
-	defer dbConn.Close()
 	if err != nil {
 		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Initiating database failed with error %v", err.Error())}
 		logger.Log(&lm)
@@ -44,20 +102,61 @@ func main() {
 		return
 	}
 
-	loginStore := store.New(dbConn, encryptionKey)
-	loginHandler := handler.New(loginStore)
+	defer dbConn.Close()
+
+	keyStore := keystore.New(dbConn, encryptionKey, maskingPolicy)
+
+	// Open already waited for the database to answer, within db.connect_max_attempts. Readiness then waits, within
+	// its own startup.max_attempts, for everything else such as the keys and the schema, and keeps serving /readyz.
+	checker := health.New(logger, 2*time.Second)
+	checker.Add("postgres", dbConn.PingContext)
+	checker.Add("encryption_key", func(context.Context) error { return model.CheckKey(encryptionKey) })
+	checker.Add("keystore", keyStore.Check)
+	checker.Add("schema", migrations.New(logger, dbConn).Check)
+
+	if err = checker.WaitReady(context.Background(), cfg.Startup.MaxAttempts, cfg.Startup.RetryInterval); err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+		logger.Log(&lm)
+
+		return
+	}
+
+	tokenVault := vault.New(dbConn, encryptionKey)
+	registry := metrics.NewRegistry()
+	database.RegisterPoolMetrics(registry, dbConn)
+	decrypts := registry.NewCounter("api_pii_decrypts_total", "Records unmasked for callers, by result.", "result")
+
+	loginStore := store.New(dbConn, encryptionKey, keyStore, cfg.Masking.PerUserKeys, maskingPolicy, tokenVault, decrypts)
+	auditStore := store.NewAudit(dbConn)
+	loginHandler := handler.New(loginStore, auditStore)
+	auditHandler := handler.NewAudit(auditStore)
+	statsHandler := handler.NewStats(store.NewStats(dbConn), minSupportedVersion)
+
+	// Metrics and health checks are served outside of the API routes, so probes need no credentials and are not logged.
+	root := mux.NewRouter().StrictSlash(true)
+	root.Handle("/metrics", registry.Handler()).Methods("GET")
+	root.HandleFunc("/healthz", checker.Healthz).Methods("GET")
+	root.HandleFunc("/readyz", checker.Readyz).Methods("GET")
 
-	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/login-data", loginHandler.Get).Methods("GET")
+	router := root.PathPrefix("/").Subrouter()
+	router.Use(middleware.Logging(logger), middleware.Metrics(registry), authenticator.Middleware)
+	router.HandleFunc("/login-data", authenticator.RequireData(loginHandler.Get)).Methods("GET")
+	router.HandleFunc("/login-data/duplicates", authenticator.RequireData(loginHandler.Duplicates)).Methods("GET")
+	router.HandleFunc("/login-data/export", authenticator.RequireData(loginHandler.Export)).Methods("GET")
+	router.HandleFunc("/users/{user_id}/logins", authenticator.RequireData(loginHandler.UserLogins)).Methods("GET")
+	router.HandleFunc("/users/{user_id}", authenticator.Require(auth.CapAdmin, loginHandler.Erase)).Methods("DELETE")
+	router.HandleFunc("/stats/logins", authenticator.Require(auth.CapAggregates, statsHandler.Logins)).Methods("GET")
+	router.HandleFunc("/reports/app-versions", authenticator.Require(auth.CapAggregates, statsHandler.AppVersions)).Methods("GET")
+	router.HandleFunc("/audit/pii-access", authenticator.Require(auth.CapAdmin, auditHandler.Search)).Methods("GET")
 
 	// Start the server
-	port := os.Getenv("PORT")
-	server := fmt.Sprintf(":%s", port)
+	port := cfg.API.Port
+	server := fmt.Sprintf(":%d", port)
 
 	lm = log.Message{Level: "INFO", Msg: fmt.Sprintf("Server starting to listen on port %v", port)}
 	logger.Log(&lm)
 
-	err = http.ListenAndServe(server, router)
+	err = http.ListenAndServe(server, root)
 	if err != nil {
 		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Initializing weapp server to listen on port %v with error %v", port, err.Error())}
 		logger.Log(&lm)
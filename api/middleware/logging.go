@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"net/http"
+	"time"
+)
+
+// statusRecorder captures the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (s *statusRecorder) WriteHeader(statusCode int) {
+	if s.statusCode == 0 {
+		s.statusCode = statusCode
+	}
+
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.statusCode == 0 {
+		s.statusCode = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(b)
+}
+
+// FlushError flushes streamed responses such as exports, flushing before WriteHeader sends a 200.
+func (s *statusRecorder) FlushError() error {
+	if s.statusCode == 0 {
+		s.statusCode = http.StatusOK
+	}
+
+	return http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the other optional interfaces of the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Logging puts a trace ID on every request and logs one message per request with its status code and duration in
+// milliseconds. The trace ID is taken from the X-Request-ID or traceparent header or generated, and returned in
+// X-Request-ID. Only the path is logged, query parameters may hold PII search values.
+func Logging(logger *log.CustomLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			traceID := log.ParseTraceID(r.Header.Get("X-Request-ID"), r.Header.Get("traceparent"))
+			if traceID == "" {
+				traceID = log.NewTraceID()
+			}
+
+			w.Header().Set("X-Request-ID", traceID)
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r.WithContext(log.WithTraceID(r.Context(), traceID)))
+
+			if recorder.statusCode == 0 {
+				recorder.statusCode = http.StatusOK
+			}
+
+			level := "INFO"
+			if recorder.statusCode >= http.StatusInternalServerError {
+				level = "ERROR"
+			}
+
+			lm := log.Message{Level: level, TraceId: traceID, Method: r.Method, URI: r.URL.Path, StatusCode: recorder.statusCode,
+				Duration: time.Since(start).Milliseconds()}
+			logger.Log(&lm)
+		})
+	}
+}
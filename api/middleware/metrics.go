@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/internal/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics counts requests and their latency by route template, method and status code. Routes are labelled with their
+// template, e.g. /users/{user_id}/logins, so user IDs never become label values.
+func Metrics(registry *metrics.Registry) func(http.Handler) http.Handler {
+	requests := registry.NewCounter("api_requests_total", "Requests served, by route, method and status code.", "route", "method", "status")
+	latency := registry.NewHistogram("api_request_duration_seconds", "Latency of requests, by route, method and status code.", metrics.DefBuckets, "route", "method", "status")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r)
+
+			if recorder.statusCode == 0 {
+				recorder.statusCode = http.StatusOK
+			}
+
+			route := "unmatched"
+			if current := mux.CurrentRoute(r); current != nil {
+				if template, err := current.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+
+			status := strconv.Itoa(recorder.statusCode)
+			requests.Inc(route, r.Method, status)
+			latency.Observe(time.Since(start).Seconds(), route, r.Method, status)
+		})
+	}
+}
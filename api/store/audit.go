@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+)
+
+type auditStore struct {
+	dbConn *sql.DB
+}
+
+// NewAudit returns the store of the PII access audit log. Entries are only ever appended.
+func NewAudit(dbConn *sql.DB) Audit {
+	return &auditStore{
+		dbConn: dbConn,
+	}
+}
+
+// Record appends an entry to the audit log.
+func (a auditStore) Record(ctx context.Context, entry *model.AuditEntry) error {
+	filters, err := json.Marshal(entry.Filters)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error recording PII access: %v", err.Error()))
+	}
+
+	userIDs := entry.UserIDs
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+
+	insertQuery := "INSERT INTO pii_access_audit (request_id, subject, role, auth_method, endpoint, reason, filters, user_ids, create_date) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW() AT TIME ZONE 'UTC') RETURNING id, create_date;"
+
+	err = a.dbConn.QueryRowContext(ctx, log.Traced(ctx, insertQuery), entry.RequestID, entry.Subject, entry.Role, entry.AuthMethod, entry.Endpoint, entry.Reason, filters, pq.Array(userIDs)).
+		Scan(&entry.ID, &entry.CreatedDate)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error recording PII access: %v", err.Error()))
+	}
+
+	return nil
+}
+
+// Search returns the audit entries matching the filter, most recent first.
+func (a auditStore) Search(ctx context.Context, filter *model.AuditFilter) (*model.AuditPage, error) {
+	page := model.AuditPage{Items: []model.AuditEntry{}}
+
+	var q query
+	if filter.Subject != "" {
+		q.where("subject = ?", filter.Subject)
+	}
+
+	if filter.UserID != "" {
+		q.where("user_ids @> ARRAY[?]::varchar[]", filter.UserID)
+	}
+
+	if filter.RequestID != "" {
+		q.where("request_id = ?", filter.RequestID)
+	}
+
+	if filter.From != nil {
+		q.where("create_date >= ?", *filter.From)
+	}
+
+	if filter.To != nil {
+		q.where("create_date <= ?", *filter.To)
+	}
+
+	if filter.Cursor != nil {
+		q.where("(create_date, id) < (?, ?)", filter.Cursor.CreatedDate, filter.Cursor.ID)
+	}
+
+	searchQuery := fmt.Sprintf("SELECT id, request_id, subject, role, auth_method, endpoint, reason, filters, user_ids, create_date "+
+		"FROM pii_access_audit %s ORDER BY create_date DESC, id DESC LIMIT %s;", q.whereClause(), q.bind(filter.Limit+1))
+
+	rows, err := a.dbConn.QueryContext(ctx, log.Traced(ctx, searchQuery), q.args...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error searching audit log: %v", err.Error()))
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry model.AuditEntry
+		var filters []byte
+
+		err = rows.Scan(&entry.ID, &entry.RequestID, &entry.Subject, &entry.Role, &entry.AuthMethod, &entry.Endpoint, &entry.Reason, &filters, pq.Array(&entry.UserIDs), &entry.CreatedDate)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error searching audit log: %v", err.Error()))
+		}
+
+		if err = json.Unmarshal(filters, &entry.Filters); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error searching audit log: %v", err.Error()))
+		}
+
+		page.Items = append(page.Items, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error searching audit log: %v", err.Error()))
+	}
+
+	if len(page.Items) > filter.Limit {
+		page.Items = page.Items[:filter.Limit]
+		page.HasMore = true
+
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = model.Cursor{CreatedDate: last.CreatedDate, ID: last.ID}.Encode()
+	}
+
+	return &page, nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+)
+
+// maxClusterMembers caps the member rows returned per cluster.
+const maxClusterMembers = 100
+
+// Duplicates returns clusters of logins sharing a masked IP and masked device ID, largest clusters first.
+func (l loginStore) Duplicates(ctx context.Context, filter *model.DuplicateFilter) (*model.ClusterPage, error) {
+	page := model.ClusterPage{Items: []model.DuplicateCluster{}}
+
+	q, err := l.filterQuery(ctx, &filter.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching duplicates: %w", err)
+	}
+
+	if q == nil {
+		return &page, nil
+	}
+
+	// Erased users no longer share anything, and records without a key can not be grouped.
+	q.where("NOT shredded AND masked_ip IS NOT NULL AND masked_device_id IS NOT NULL")
+
+	q.having("COUNT(*) >= ?", filter.MinSize)
+	if filter.MultiUser {
+		q.having("COUNT(DISTINCT user_id) > 1")
+	}
+
+	// Continue right after the last cluster of the previous page.
+	if c := filter.ClusterCursor; c != nil {
+		q.having("(COUNT(*) < ? OR (COUNT(*) = ? AND (masked_ip, masked_device_id) > (?, ?)))", c.Size, c.Size, c.IP, c.DeviceID)
+	}
+
+	getQuery := fmt.Sprintf("SELECT masked_ip, masked_device_id, COUNT(*), array_remove(array_agg(DISTINCT user_id), NULL), MIN(create_date), MAX(create_date), MIN(user_id), bool_or(per_user_key) "+
+		"FROM user_logins %s GROUP BY masked_ip, masked_device_id %s ORDER BY COUNT(*) DESC, masked_ip, masked_device_id LIMIT %s;", q.whereClause(), q.havingClause(), q.bind(filter.Limit+1))
+
+	rows, err := l.dbConn.QueryContext(ctx, log.Traced(ctx, getQuery), q.args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching duplicates: %w", err)
+	}
+
+	defer rows.Close()
+
+	// keys holds a record per cluster carrying the shared key, so it can be unmasked like any other record.
+	var keys []model.Response
+
+	for rows.Next() {
+		var cluster model.DuplicateCluster
+		var key model.Response
+
+		err = rows.Scan(&cluster.MaskedIP, &cluster.MaskedDeviceID, &cluster.Count, pq.Array(&cluster.UserIDs), &cluster.FirstSeen, &cluster.LastSeen, &key.UserID, &key.PerUserKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching duplicates: %w", err)
+		}
+
+		maskedIP, maskedDeviceID := cluster.MaskedIP, cluster.MaskedDeviceID
+		key.IP, key.DeviceID = &maskedIP, &maskedDeviceID
+
+		page.Items = append(page.Items, cluster)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error fetching duplicates: %w", err)
+	}
+
+	if len(page.Items) > filter.Limit {
+		page.Items, keys = page.Items[:filter.Limit], keys[:filter.Limit]
+		page.HasMore = true
+
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = model.ClusterCursor{Size: last.Count, IP: last.MaskedIP, DeviceID: last.MaskedDeviceID}.Encode()
+	}
+
+	if !filter.IsEncrypted {
+		if err = l.unmask(ctx, keys, &filter.Filter); err != nil {
+			return nil, fmt.Errorf("Error fetching duplicates: %w", err)
+		}
+	}
+
+	for i := range page.Items {
+		page.Items[i].IP, page.Items[i].DeviceID = keys[i].IP, keys[i].DeviceID
+	}
+
+	if filter.IncludeMembers && len(page.Items) > 0 {
+		if err = l.clusterMembers(ctx, page.Items, filter); err != nil {
+			return nil, fmt.Errorf("Error fetching duplicates: %w", err)
+		}
+	}
+
+	return &page, nil
+}
+
+// clusterMembers fills in the most recent member records of each cluster, applying the same filters as the clusters.
+func (l loginStore) clusterMembers(ctx context.Context, clusters []model.DuplicateCluster, filter *model.DuplicateFilter) error {
+	q, err := l.filterQuery(ctx, &filter.Filter)
+	if err != nil || q == nil {
+		return err
+	}
+
+	maskedIPs := make([]string, 0, len(clusters))
+	maskedDeviceIDs := make([]string, 0, len(clusters))
+	index := make(map[[2]string]int, len(clusters))
+
+	for i, cluster := range clusters {
+		maskedIPs = append(maskedIPs, cluster.MaskedIP)
+		maskedDeviceIDs = append(maskedDeviceIDs, cluster.MaskedDeviceID)
+		index[[2]string{cluster.MaskedIP, cluster.MaskedDeviceID}] = i
+	}
+
+	q.where("NOT shredded")
+	q.where("(masked_ip, masked_device_id) IN (SELECT * FROM unnest(?::text[], ?::text[]))", pq.Array(maskedIPs), pq.Array(maskedDeviceIDs))
+
+	getQuery := fmt.Sprintf("SELECT %s FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY masked_ip, masked_device_id ORDER BY create_date DESC, id DESC) AS rn FROM user_logins %s) AS members WHERE rn <= %s ORDER BY create_date DESC, id DESC;",
+		loginColumns, q.whereClause(), q.bind(maxClusterMembers))
+
+	members, err := l.query(ctx, getQuery, q.args)
+	if err != nil {
+		return err
+	}
+
+	// The stored key is kept before the members are unmasked, it identifies the cluster they belong to.
+	keys := make([][2]string, len(members))
+	for i, member := range members {
+		keys[i] = [2]string{*member.IP, *member.DeviceID}
+	}
+
+	if !filter.IsEncrypted {
+		if err = l.unmask(ctx, members, &filter.Filter); err != nil {
+			return err
+		}
+	}
+
+	for i, member := range members {
+		cluster := &clusters[index[keys[i]]]
+		cluster.Members = append(cluster.Members, member)
+	}
+
+	return nil
+}
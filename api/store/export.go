@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+)
+
+// exportBatchSize is the number of records fetched from the export cursor at a time.
+const exportBatchSize = 1000
+
+// Export streams every record matching the filter to write, most recent first, in batches of at most exportBatchSize.
+// The records are read through a server-side cursor, so memory stays bounded however many records match.
+// begin is called once before the first batch. When the records are unmasked it gets the users whose PII is about to be
+// revealed, read from the same snapshot as the records.
+func (l loginStore) Export(ctx context.Context, filter *model.Filter, begin func(userIDs []string) error, write func(records []model.Response) error) error {
+	q, err := l.filterQuery(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("Error exporting records: %w", err)
+	}
+
+	if q == nil {
+		return begin(nil)
+	}
+
+	// A repeatable read snapshot keeps the audited users and the exported records consistent.
+	tx, err := l.dbConn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("Error exporting records: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	exportQuery := listQuery(filter, q)
+
+	var userIDs []string
+	if !filter.IsEncrypted {
+		userIDs, err = l.exportUserIDs(ctx, tx, exportQuery, q.args, filter)
+		if err != nil {
+			return fmt.Errorf("Error exporting records: %w", err)
+		}
+	}
+
+	if err = begin(userIDs); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, log.Traced(ctx, "DECLARE login_export NO SCROLL CURSOR FOR "+exportQuery), q.args...); err != nil {
+		return fmt.Errorf("Error exporting records: %w", err)
+	}
+
+	for {
+		records, err := l.fetch(ctx, tx, fmt.Sprintf("FETCH %d FROM login_export;", exportBatchSize))
+		if err != nil {
+			return fmt.Errorf("Error exporting records: %w", err)
+		}
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		if !filter.IsEncrypted {
+			if err = l.unmask(ctx, records, filter); err != nil {
+				return fmt.Errorf("Error exporting records: %w", err)
+			}
+		}
+
+		if err = write(records); err != nil {
+			return err
+		}
+
+		if len(records) < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+// exportUserIDs returns the distinct users of the records matching the export query whose PII can still be unmasked.
+func (l loginStore) exportUserIDs(ctx context.Context, tx *sql.Tx, exportQuery string, args []interface{}, filter *model.Filter) ([]string, error) {
+	distinctQuery := fmt.Sprintf("SELECT DISTINCT user_id, per_user_key FROM (%s) AS export WHERE NOT shredded AND user_id IS NOT NULL;", exportQuery)
+
+	rows, err := tx.QueryContext(ctx, log.Traced(ctx, distinctQuery), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []model.Response
+	for rows.Next() {
+		var user model.Response
+		if err = rows.Scan(&user.UserID, &user.PerUserKey); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The user id itself may be masked by the policy.
+	if err = l.unmask(ctx, users, filter); err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		if !user.Shredded {
+			userIDs = append(userIDs, *user.UserID)
+		}
+	}
+
+	return userIDs, nil
+}
+
+// fetch runs a FETCH of the export cursor.
+func (l loginStore) fetch(ctx context.Context, tx *sql.Tx, fetchQuery string) ([]model.Response, error) {
+	rows, err := tx.QueryContext(ctx, log.Traced(ctx, fetchQuery))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return l.scan(rows)
+}
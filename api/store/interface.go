@@ -1,7 +1,24 @@
 package store
 
-import "github.com/shivasaicharanruthala/dataops-takehome-2/model"
+import (
+	"context"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+)
 
 type Login interface {
-	Get(filter *model.Filter) ([]model.Response, error)
+	Get(ctx context.Context, filter *model.Filter) (*model.Page, error)
+	Duplicates(ctx context.Context, filter *model.DuplicateFilter) (*model.ClusterPage, error)
+	UserLogins(ctx context.Context, filter *model.Filter) (*model.UserLoginHistory, error)
+	Export(ctx context.Context, filter *model.Filter, begin func(userIDs []string) error, write func(records []model.Response) error) error
+	Erase(ctx context.Context, userID string) (int64, error)
+}
+
+type Stats interface {
+	Logins(ctx context.Context, filter *model.StatsFilter) (*model.LoginStats, error)
+	AppVersions(ctx context.Context, filter *model.StatsFilter, minSupported *model.Version) (*model.AppVersionReport, error)
+}
+
+type Audit interface {
+	Record(ctx context.Context, entry *model.AuditEntry) error
+	Search(ctx context.Context, filter *model.AuditFilter) (*model.AuditPage, error)
 }
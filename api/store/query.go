@@ -0,0 +1,65 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// query collects the conditions of a statement. Values are never formatted into the SQL, every value is bound to a
+// numbered placeholder.
+type query struct {
+	conditions []string
+	havings    []string
+	args       []interface{}
+}
+
+// bind adds a value to the arguments and returns its placeholder.
+func (q *query) bind(value interface{}) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where adds a condition, every ? in it is replaced by the placeholder of the next value.
+func (q *query) where(condition string, values ...interface{}) {
+	q.conditions = append(q.conditions, q.expand(condition, values))
+}
+
+// having adds a condition on the groups of an aggregate query, placeholders work as in where.
+func (q *query) having(condition string, values ...interface{}) {
+	q.havings = append(q.havings, q.expand(condition, values))
+}
+
+// whereClause returns the WHERE clause joining all conditions with the extra ones, or an empty string.
+func (q *query) whereClause(extra ...string) string {
+	return clause("WHERE", append(append([]string{}, q.conditions...), extra...))
+}
+
+// havingClause returns the HAVING clause joining all group conditions, or an empty string.
+func (q *query) havingClause() string {
+	return clause("HAVING", q.havings)
+}
+
+func (q *query) expand(condition string, values []interface{}) string {
+	var sb strings.Builder
+
+	next := 0
+	for _, c := range condition {
+		if c == '?' && next < len(values) {
+			sb.WriteString(q.bind(values[next]))
+			next++
+			continue
+		}
+
+		sb.WriteRune(c)
+	}
+
+	return sb.String()
+}
+
+func clause(keyword string, conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return keyword + " " + strings.Join(conditions, " AND ")
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+)
+
+// StatsGroupColumns maps the group_by values accepted by the stats to their columns.
+var StatsGroupColumns = map[string]string{
+	"device_type": "device_type",
+	"locale":      "locale",
+	"app_version": "app_version",
+}
+
+type statsStore struct {
+	dbConn *sql.DB
+}
+
+func NewStats(dbConn *sql.DB) Stats {
+	return &statsStore{
+		dbConn: dbConn,
+	}
+}
+
+// Logins counts logins and distinct users per time bucket, and per group within each bucket when GroupBy is set.
+func (s statsStore) Logins(ctx context.Context, filter *model.StatsFilter) (*model.LoginStats, error) {
+	stats := model.LoginStats{Bucket: filter.Bucket, GroupBy: filter.GroupBy, From: filter.From, To: filter.To, Items: []model.LoginStat{}}
+
+	q := &query{}
+	bucket := q.bind(filter.Bucket)
+	q.where("create_date >= ? AND create_date < ?", filter.From, filter.To)
+
+	// Group columns come from a fixed list, only values are bound.
+	group := "NULL::text"
+	if filter.GroupBy != "" {
+		column, ok := StatsGroupColumns[filter.GroupBy]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Error fetching stats: unknown group %v", filter.GroupBy))
+		}
+
+		group = column
+	}
+
+	statsQuery := fmt.Sprintf("SELECT date_trunc(%s, create_date) AS bucket, %s AS grp, COUNT(*), COUNT(DISTINCT user_id) FROM user_logins %s GROUP BY bucket, grp ORDER BY bucket, grp;", bucket, group, q.whereClause())
+
+	rows, err := s.dbConn.QueryContext(ctx, log.Traced(ctx, statsQuery), q.args...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error fetching stats: %v", err.Error()))
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var stat model.LoginStat
+
+		if err = rows.Scan(&stat.Bucket, &stat.Group, &stat.Logins, &stat.DistinctUsers); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error fetching stats: %v", err.Error()))
+		}
+
+		stats.Items = append(stats.Items, stat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error fetching stats: %v", err.Error()))
+	}
+
+	return &stats, nil
+}
+
+// strippedVersion is app_version without surrounding whitespace, a leading "v" and pre-release or build suffixes.
+const strippedVersion = `regexp_replace(regexp_replace(app_version, '^\s+|\s+$', '', 'g'), '^v?([^-+]*).*$', '\1')`
+
+// normalizedVersion is app_version as major.minor.patch with missing parts set to zero, like model.ParseVersion, or
+// model.Unknown when it is not a version.
+var normalizedVersion = fmt.Sprintf(`(CASE WHEN %[1]s ~ '^[0-9]{1,18}(\.[0-9]{1,18}){0,2}$' `+
+	`THEN array_to_string((string_to_array(%[1]s || '.0.0', '.'))[1:3]::numeric[], '.') ELSE '%[2]s' END)`, strippedVersion, model.Unknown)
+
+// AppVersions reports the adoption of app versions per device type in the filter's time range.
+func (s statsStore) AppVersions(ctx context.Context, filter *model.StatsFilter, minSupported *model.Version) (*model.AppVersionReport, error) {
+	q := &query{}
+	q.where("create_date >= ? AND create_date < ?", filter.From, filter.To)
+
+	// Versions are normalized before grouping so that distinct users are counted once per major.minor.patch version.
+	// Missing device types are grouped as empty strings, so that NULL only marks the per version, per device type and
+	// overall totals.
+	reportQuery := fmt.Sprintf("WITH logins AS (SELECT user_id, COALESCE(device_type, '') AS device_type, %s AS version FROM user_logins %s) "+
+		"SELECT version, device_type, COUNT(*), COUNT(DISTINCT user_id) FROM logins "+
+		"GROUP BY GROUPING SETS ((version, device_type), (version), (device_type), ());", normalizedVersion, q.whereClause())
+
+	rows, err := s.dbConn.QueryContext(ctx, log.Traced(ctx, reportQuery), q.args...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error fetching app versions: %v", err.Error()))
+	}
+
+	defer rows.Close()
+
+	var counts []model.AppVersionCount
+	for rows.Next() {
+		var c model.AppVersionCount
+
+		if err = rows.Scan(&c.AppVersion, &c.DeviceType, &c.Logins, &c.Users); err != nil {
+			return nil, errors.New(fmt.Sprintf("Error fetching app versions: %v", err.Error()))
+		}
+
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error fetching app versions: %v", err.Error()))
+	}
+
+	report := model.NewAppVersionReport(filter.From, filter.To, counts, minSupported)
+
+	return &report, nil
+}
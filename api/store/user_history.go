@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"sort"
+)
+
+// UserLogins returns one page of the logins of filter.UserID together with a summary of all their logins in the
+// filter's time window.
+func (l loginStore) UserLogins(ctx context.Context, filter *model.Filter) (*model.UserLoginHistory, error) {
+	history := model.UserLoginHistory{
+		UserID: filter.UserID,
+		Summary: model.UserLoginSummary{
+			DistinctDevices: []string{}, DistinctIPs: []string{}, DeviceTypes: []string{}, AppVersions: []string{},
+		},
+	}
+
+	logins, err := l.Get(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	history.Logins = logins
+
+	// The summary only depends on the user and the time window, not on the page or the other filters.
+	q, err := l.filterQuery(ctx, &model.Filter{UserID: filter.UserID, From: filter.From, To: filter.To})
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching user summary: %w", err)
+	}
+
+	if q == nil {
+		return &history, nil
+	}
+
+	summary := &history.Summary
+
+	summaryQuery := fmt.Sprintf("SELECT COUNT(*), MIN(create_date), MAX(create_date), array_remove(array_agg(DISTINCT device_type), NULL), array_remove(array_agg(DISTINCT app_version), NULL) FROM user_logins %s;", q.whereClause())
+
+	err = l.dbConn.QueryRowContext(ctx, log.Traced(ctx, summaryQuery), q.args...).Scan(&summary.TotalLogins, &summary.FirstLogin, &summary.LastLogin, pq.Array(&summary.DeviceTypes), pq.Array(&summary.AppVersions))
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching user summary: %w", err)
+	}
+
+	// Distinct masked values are unmasked one by one, records may be encrypted with the encryption key or a per-user key.
+	distinctQuery := fmt.Sprintf("SELECT DISTINCT user_id, masked_ip, masked_device_id, per_user_key FROM user_logins %s;", q.whereClause("NOT shredded"))
+
+	rows, err := l.dbConn.QueryContext(ctx, log.Traced(ctx, distinctQuery), q.args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching user summary: %w", err)
+	}
+
+	defer rows.Close()
+
+	var values []model.Response
+	for rows.Next() {
+		var value model.Response
+
+		if err = rows.Scan(&value.UserID, &value.IP, &value.DeviceID, &value.PerUserKey); err != nil {
+			return nil, fmt.Errorf("Error fetching user summary: %w", err)
+		}
+
+		values = append(values, value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error fetching user summary: %w", err)
+	}
+
+	if !filter.IsEncrypted {
+		if err = l.unmask(ctx, values, filter); err != nil {
+			return nil, fmt.Errorf("Error fetching user summary: %w", err)
+		}
+	}
+
+	summary.DistinctIPs = distinct(values, func(r model.Response) *string { return r.IP })
+	summary.DistinctDevices = distinct(values, func(r model.Response) *string { return r.DeviceID })
+
+	return &history, nil
+}
+
+// distinct returns the sorted distinct non-empty values of a field of the records.
+func distinct(records []model.Response, field func(model.Response) *string) []string {
+	seen := make(map[string]bool)
+	values := []string{}
+
+	for _, record := range records {
+		value := field(record)
+		if value == nil || *value == "" || *value == model.ErasedMarker || seen[*value] {
+			continue
+		}
+
+		seen[*value] = true
+		values = append(values, *value)
+	}
+
+	sort.Strings(values)
+
+	return values
+}
@@ -1,66 +1,286 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/internal/metrics"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/keystore"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/vault"
 )
 
+// appVersionParts is app_version as a [major, minor, patch] array with missing parts set to zero, or NULL when the
+// version is not numeric so that it never matches a range. The parts are numeric so that no version overflows the cast.
+const appVersionParts = `(CASE WHEN app_version ~ '^[0-9]+(\.[0-9]+){0,2}$' THEN (string_to_array(app_version || '.0.0', '.'))[1:3]::numeric[] END)`
+
+// FilterError is returned when a filter can not be applied to the stored records, so callers answer it as a bad request.
+type FilterError struct {
+	Reason string
+}
+
+func (e FilterError) Error() string {
+	return e.Reason
+}
+
+// loginColumns are the columns scanned by query, in order.
+const loginColumns = "id, user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date, per_user_key, shredded, host(ip_pseudonym)"
+
 type loginStore struct {
 	dbConn        *sql.DB
 	encryptionKey string
+	keyStore      keystore.KeyStore
+	perUserKeys   bool
+	policy        model.MaskingPolicy
+	tokenVault    vault.Vault
+	decrypts      *metrics.Counter
 }
 
-func New(dbConn *sql.DB, encryptionKey string) Login {
+// New returns the login store. perUserKeys tells that the ETL encrypts with per-user data keys, so encrypted fields
+// can not be searched. decrypts counts the records unmasked for callers by result, ok or error.
+func New(dbConn *sql.DB, encryptionKey string, keyStore keystore.KeyStore, perUserKeys bool, policy model.MaskingPolicy, tokenVault vault.Vault, decrypts *metrics.Counter) Login {
 	return &loginStore{
 		dbConn:        dbConn,
 		encryptionKey: encryptionKey,
+		keyStore:      keyStore,
+		perUserKeys:   perUserKeys,
+		policy:        policy,
+		tokenVault:    tokenVault,
+		decrypts:      decrypts,
 	}
 }
 
-func (l loginStore) Get(filter *model.Filter) ([]model.Response, error) {
-	var userLoginList []model.Response
+func (l loginStore) Get(ctx context.Context, filter *model.Filter) (*model.Page, error) {
+	page := model.Page{Items: []model.Response{}}
+
+	q, err := l.filterQuery(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching records: %w", err)
+	}
+
+	// One of the search values was never stored, e.g. it has no token in the vault.
+	if q == nil {
+		return &page, nil
+	}
+
+	// Continue right after the last record of the previous page.
+	if filter.Cursor != nil {
+		q.where("(create_date, id) < (?, ?)", filter.Cursor.CreatedDate, filter.Cursor.ID)
+	}
+
+	// One extra record is fetched to tell whether there is a next page.
+	getQuery := fmt.Sprintf("%s LIMIT %s;", listQuery(filter, q), q.bind(filter.Limit+1))
+
+	page.Items, err = l.query(ctx, getQuery, q.args)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching records: %w", err)
+	}
 
-	offset := 1
-	getQuery := fmt.Sprintf("SELECT user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date FROM user_logins ORDER BY create_date DESC LIMIT %v OFFSET %v;", filter.Limit, filter.Page*offset)
+	if len(page.Items) > filter.Limit {
+		page.Items = page.Items[:filter.Limit]
+		page.HasMore = true
 
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = model.Cursor{CreatedDate: last.CreatedDate, ID: last.ID}.Encode()
+	}
+
+	if !filter.IsEncrypted {
+		if err = l.unmask(ctx, page.Items, filter); err != nil {
+			return nil, fmt.Errorf("Error fetching records: %w", err)
+		}
+	}
+
+	return &page, nil
+}
+
+// listQuery returns the select of loginColumns matching the query conditions, most recent first.
+func listQuery(filter *model.Filter, q *query) string {
 	if filter.GroupDuplicates {
-		getQuery = fmt.Sprintf("WITH DuplicateRecords AS (SELECT *, ROW_NUMBER() OVER (PARTITION BY masked_ip, masked_device_id ORDER BY create_date) AS rn FROM user_logins) SELECT user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date FROM DuplicateRecords WHERE rn > 1 ORDER BY create_date DESC LIMIT %v OFFSET %v;", filter.Limit, filter.Page*offset)
+		return fmt.Sprintf("WITH DuplicateRecords AS (SELECT *, ROW_NUMBER() OVER (PARTITION BY masked_ip, masked_device_id ORDER BY create_date, id) AS rn FROM user_logins) SELECT %s FROM DuplicateRecords %s ORDER BY create_date DESC, id DESC", loginColumns, q.whereClause("rn > 1"))
 	}
 
-	rows, err := l.dbConn.Query(getQuery)
+	return fmt.Sprintf("SELECT %s FROM user_logins %s ORDER BY create_date DESC, id DESC", loginColumns, q.whereClause())
+}
+
+// query runs a select of loginColumns and scans the records, records of erased users are marked as erased.
+func (l loginStore) query(ctx context.Context, getQuery string, args []interface{}) ([]model.Response, error) {
+	rows, err := l.dbConn.QueryContext(ctx, log.Traced(ctx, getQuery), args...)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Error fetching records: %v", err.Error()))
+		return nil, err
 	}
 
+	defer rows.Close()
+
+	return l.scan(rows)
+}
+
+// scan reads rows of loginColumns, records of erased users are marked as erased.
+func (l loginStore) scan(rows *sql.Rows) ([]model.Response, error) {
+	userLoginList := []model.Response{}
+
 	for rows.Next() {
 		var userLogin model.Response
 
-		err = rows.Scan(&userLogin.UserID, &userLogin.DeviceType, &userLogin.IP, &userLogin.DeviceID, &userLogin.Locale, &userLogin.AppVersion, &userLogin.CreatedDate)
+		err := rows.Scan(&userLogin.ID, &userLogin.UserID, &userLogin.DeviceType, &userLogin.IP, &userLogin.DeviceID, &userLogin.Locale, &userLogin.AppVersion, &userLogin.CreatedDate, &userLogin.PerUserKey, &userLogin.Shredded, &userLogin.IPPseudonym)
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Error fetching records: %v", err.Error()))
+			return nil, err
+		}
+
+		if userLogin.Shredded {
+			userLogin.MarkErased(l.policy)
 		}
 
 		userLoginList = append(userLoginList, userLogin)
 	}
 
-	if !filter.IsEncrypted {
-		for _, userLogin := range userLoginList {
-			decryptedDeviceID, err := model.Decrypt(*userLogin.DeviceID, l.encryptionKey)
-			if err != nil {
-				return nil, errors.New(fmt.Sprintf("Error fetching records: %v", err.Error()))
-			}
+	return userLoginList, rows.Err()
+}
+
+// unmask reverses the masked fields of the records in place, records of erased users are marked as erased.
+func (l loginStore) unmask(ctx context.Context, userLogins []model.Response, filter *model.Filter) error {
+	// Data keys fetched for this request, keyed by user id.
+	userKeys := make(map[string]string)
+
+	for i := range userLogins {
+		userLogin := &userLogins[i]
+		if userLogin.Shredded {
+			continue
+		}
+
+		key := l.encryptionKey
+		if userLogin.PerUserKey {
+			userKey, ok := userKeys[*userLogin.UserID]
+			if !ok {
+				var err error
+
+				userKey, err = l.keyStore.Lookup(ctx, *userLogin.UserID)
+				if errors.Is(err, keystore.ErrKeyShredded) {
+					// The user was erased after these records were read.
+					userLogin.MarkErased(l.policy)
+					continue
+				}
 
-			decryptedIP, err := model.Decrypt(*userLogin.IP, l.encryptionKey)
-			if err != nil {
-				return nil, errors.New(fmt.Sprintf("Error fetching records: %v", err.Error()))
+				if err != nil {
+					l.decrypts.Inc("error")
+					return err
+				}
+
+				userKeys[*userLogin.UserID] = userKey
 			}
 
-			*userLogin.DeviceID = *decryptedDeviceID
-			*userLogin.IP = *decryptedIP
+			key = userKey
+		}
+
+		// Tokens are only swapped back through the vault for authorized callers.
+		keys := model.MaskingKeys{EncryptionKey: key}
+		if filter.Detokenize {
+			keys.Tokenizer = l.tokenVault
+		}
+
+		// Encrypted fields are decrypted, hashed, truncated and partial fields are returned as they were stored.
+		if err := userLogin.UnmaskBody(ctx, l.policy, keys); err != nil {
+			l.decrypts.Inc("error")
+			return err
+		}
+
+		l.decrypts.Inc("ok")
+	}
+
+	return nil
+}
+
+// filterQuery turns the filter into query conditions. It returns nil when a search value can not match any record.
+func (l loginStore) filterQuery(ctx context.Context, filter *model.Filter) (*query, error) {
+	q := &query{}
+
+	// Restrict to pseudonymous IPs inside the given pseudonymous CIDR.
+	if filter.IPPrefix != "" {
+		q.where("ip_pseudonym <<= ?::inet", filter.IPPrefix)
+	}
+
+	// Plaintext search values are masked like the ETL masks them and matched against the stored values.
+	searches := []struct{ field, column, value string }{
+		{"ip", "masked_ip", filter.IP},
+		{"device_id", "masked_device_id", filter.DeviceID},
+		{"user_id", "user_id", filter.UserID},
+		{"device_type", "device_type", filter.DeviceType},
+		{"locale", "locale", filter.Locale},
+		{"app_version", "app_version", filter.AppVersion},
+	}
+
+	for _, search := range searches {
+		if search.value == "" {
+			continue
+		}
+
+		masked, err := l.searchValue(ctx, search.field, search.value)
+		if err != nil {
+			return nil, err
 		}
+
+		if masked == nil {
+			return nil, nil
+		}
+
+		q.where(search.column+" = ?", *masked)
 	}
 
-	return userLoginList, nil
+	// Semver ranges compare [major, minor, patch], versions that are not numeric never match.
+	if filter.AppVersionMin != nil || filter.AppVersionMax != nil {
+		if l.policy.Method("app_version") != model.MethodPassthrough {
+			return nil, FilterError{Reason: "app_version is masked and can not be compared"}
+		}
+
+		if filter.AppVersionMin != nil {
+			q.where(appVersionParts+" >= ?::numeric[]", pq.Array(filter.AppVersionMin.Parts()))
+		}
+
+		if filter.AppVersionMax != nil {
+			q.where(appVersionParts+" <= ?::numeric[]", pq.Array(filter.AppVersionMax.Parts()))
+		}
+	}
+
+	if filter.From != nil {
+		q.where("create_date >= ?", *filter.From)
+	}
+
+	if filter.To != nil {
+		q.where("create_date <= ?", *filter.To)
+	}
+
+	return q, nil
+}
+
+// searchValue masks a plaintext search value of the field as defined by the masking policy.
+// Encrypted fields can not be searched with per-user keys, since the value encrypts differently for every user.
+func (l loginStore) searchValue(ctx context.Context, field, value string) (*string, error) {
+	switch l.policy.Method(field) {
+	case model.MethodDrop:
+		return nil, FilterError{Reason: fmt.Sprintf("%v is not stored and can not be searched", field)}
+	case model.MethodEncrypt:
+		if l.perUserKeys {
+			return nil, FilterError{Reason: fmt.Sprintf("%v is encrypted with per-user keys and can not be searched", field)}
+		}
+	case model.MethodTokenize:
+		token, err := l.tokenVault.Lookup(ctx, field, value)
+		if errors.Is(err, vault.ErrUnknownToken) {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &token, nil
+	}
+
+	return l.policy.MaskValue(field, value, model.MaskingKeys{EncryptionKey: l.encryptionKey, PseudonymKey: l.encryptionKey})
+}
+
+// Erase shreds the data key of the given user so none of their PII can be decrypted again.
+func (l loginStore) Erase(ctx context.Context, userID string) (int64, error) {
+	return l.keyStore.Erase(ctx, userID)
 }
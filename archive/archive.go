@@ -0,0 +1,296 @@
+// Package archive moves the logins of a time range out of user_logins into compressed files with a manifest of
+// their row counts and checksums, and loads such an archive back into a staging table. Every column is archived as
+// it is stored, the masked ones stay masked.
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	table        = "user_logins"
+	manifestFile = "manifest.json"
+
+	// batchSize is the number of logins fetched from the archive cursor at a time.
+	batchSize = 1000
+)
+
+// columns are the archived columns of user_logins in the order of Row. ip_pseudonym is archived as text, which keeps
+// the prefix length of a pseudonymous subnet.
+var columns = []string{"id", "user_id", "device_type", "masked_ip", "masked_device_id", "locale", "app_version", "create_date", "per_user_key", "shredded", "ip_pseudonym"}
+
+// Row is an archived login, its fields are the columns of every format.
+type Row struct {
+	ID             int64     `json:"id" parquet:"id"`
+	UserID         *string   `json:"user_id" parquet:"user_id"`
+	DeviceType     *string   `json:"device_type" parquet:"device_type"`
+	MaskedIP       *string   `json:"masked_ip" parquet:"masked_ip"`
+	MaskedDeviceID *string   `json:"masked_device_id" parquet:"masked_device_id"`
+	Locale         *string   `json:"locale" parquet:"locale"`
+	AppVersion     *string   `json:"app_version" parquet:"app_version"`
+	CreateDate     time.Time `json:"create_date" parquet:"create_date,timestamp(microsecond)"`
+	PerUserKey     bool      `json:"per_user_key" parquet:"per_user_key"`
+	Shredded       bool      `json:"shredded" parquet:"shredded"`
+	IPPseudonym    *string   `json:"ip_pseudonym" parquet:"ip_pseudonym"`
+}
+
+// File is an archive file with the number of logins in it, its size and the SHA-256 of its content.
+type File struct {
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes an archive, it is written next to its files as manifest.json.
+type Manifest struct {
+	Table     string    `json:"table"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Format    string    `json:"format"`
+	Columns   []string  `json:"columns"`
+	Rows      int64     `json:"rows"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []File    `json:"files"`
+}
+
+type archiver struct {
+	logger   *log.CustomLogger
+	dbConn   *sql.DB
+	settings config.Archive
+}
+
+// New returns an Archiver writing archives to the directory of the settings, in their format.
+func New(logger *log.CustomLogger, dbConn *sql.DB, settings config.Archive) Archiver {
+	return &archiver{
+		logger:   logger,
+		dbConn:   dbConn,
+		settings: settings,
+	}
+}
+
+// Name returns the directory name of the archive of [from, to), e.g. user_logins_20240501_20240601.
+func Name(from, to time.Time) string {
+	return fmt.Sprintf("%s_%s_%s", table, from.Format("20060102"), to.Format("20060102"))
+}
+
+// Archive writes the files to a hidden directory and deletes the logins in the same repeatable read snapshot they
+// were read from, so exactly the archived logins are deleted and logins inserted meanwhile are kept. The directory is
+// only renamed to the archive name once the manifest is written. It is kept when the commit fails, since the server
+// may have committed the delete before the connection failed and the archive may be the only copy of the logins.
+func (a *archiver) Archive(ctx context.Context, from, to time.Time) (Manifest, error) {
+	manifest := Manifest{Table: table, From: from.UTC(), To: to.UTC(), Format: a.settings.Format, Columns: columns, CreatedAt: time.Now().UTC()}
+
+	if !from.Before(to) {
+		return manifest, errors.New(fmt.Sprintf("the archived range is empty, %v is not before %v", from.Format(time.RFC3339), to.Format(time.RFC3339)))
+	}
+
+	dir := filepath.Join(a.settings.Dir, Name(from, to))
+	if _, err := os.Stat(dir); err == nil {
+		return manifest, errors.New(fmt.Sprintf("archive %v already exists", dir))
+	}
+
+	if err := os.MkdirAll(a.settings.Dir, 0o755); err != nil {
+		return manifest, err
+	}
+
+	partial, err := os.MkdirTemp(a.settings.Dir, "."+Name(from, to)+"-")
+	if err != nil {
+		return manifest, err
+	}
+
+	// Nothing is left behind once the directory is renamed.
+	defer os.RemoveAll(partial)
+
+	tx, err := a.dbConn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error archiving logins: %v", err.Error()))
+	}
+
+	defer tx.Rollback()
+
+	archiveQuery := fmt.Sprintf("DECLARE login_archive NO SCROLL CURSOR FOR SELECT %s FROM %s WHERE create_date >= $1 AND create_date < $2 ORDER BY create_date, id",
+		strings.Join(selectColumns(), ", "), table)
+	if _, err = tx.ExecContext(ctx, archiveQuery, from, to); err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error archiving logins: %v", err.Error()))
+	}
+
+	if manifest.Files, err = a.writeFiles(ctx, tx, partial); err != nil {
+		return manifest, err
+	}
+
+	for _, file := range manifest.Files {
+		manifest.Rows += file.Rows
+	}
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE create_date >= $1 AND create_date < $2", table), from, to)
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error deleting archived logins: %v", err.Error()))
+	}
+
+	if deleted, _ := res.RowsAffected(); deleted != manifest.Rows {
+		return manifest, errors.New(fmt.Sprintf("Error deleting archived logins: deleted %d logins but archived %d", deleted, manifest.Rows))
+	}
+
+	if err = writeManifest(partial, manifest); err != nil {
+		return manifest, err
+	}
+
+	if err = os.Rename(partial, dir); err != nil {
+		return manifest, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error committing the delete of the logins archived to %v, it may or may not have been committed: "+
+			"compare the %d logins of its manifest with user_logins before removing the archive or archiving the range again: %v", dir, manifest.Rows, err.Error()))
+	}
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Archived and deleted %d logins created in [%v, %v) to %v in %d files", manifest.Rows,
+		from.Format(time.RFC3339), to.Format(time.RFC3339), dir, len(manifest.Files))}
+	a.logger.Log(&lm)
+
+	return manifest, nil
+}
+
+// writeFiles writes the logins of the cursor to files of at most RowsPerFile logins each.
+func (a *archiver) writeFiles(ctx context.Context, tx *sql.Tx, dir string) ([]File, error) {
+	var files []File
+	var out *fileWriter
+
+	for {
+		rows, err := fetch(ctx, tx)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error archiving logins: %v", err.Error()))
+		}
+
+		fetched := len(rows)
+
+		for len(rows) > 0 {
+			if out == nil {
+				if out, err = newFileWriter(dir, len(files), a.settings.Format); err != nil {
+					return nil, err
+				}
+			}
+
+			n := min(len(rows), a.settings.RowsPerFile-int(out.rows))
+			if err = out.Write(rows[:n]); err != nil {
+				return nil, err
+			}
+
+			rows = rows[n:]
+
+			if int(out.rows) == a.settings.RowsPerFile {
+				file, err := out.Close()
+				if err != nil {
+					return nil, err
+				}
+
+				files, out = append(files, file), nil
+			}
+		}
+
+		if fetched < batchSize {
+			break
+		}
+	}
+
+	if out != nil {
+		file, err := out.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// fetch returns the next batch of logins of the archive cursor.
+func fetch(ctx context.Context, tx *sql.Tx) ([]Row, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM login_archive", batchSize))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var batch []Row
+	for rows.Next() {
+		var row Row
+
+		err = rows.Scan(&row.ID, &row.UserID, &row.DeviceType, &row.MaskedIP, &row.MaskedDeviceID, &row.Locale, &row.AppVersion, &row.CreateDate, &row.PerUserKey, &row.Shredded, &row.IPPseudonym)
+		if err != nil {
+			return nil, err
+		}
+
+		row.CreateDate = row.CreateDate.UTC()
+		batch = append(batch, row)
+	}
+
+	return batch, rows.Err()
+}
+
+// selectColumns returns the columns as they are selected, ip_pseudonym as text.
+func selectColumns() []string {
+	selected := make([]string, len(columns))
+	for i, column := range columns {
+		selected[i] = column
+		if column == "ip_pseudonym" {
+			selected[i] = "ip_pseudonym::text"
+		}
+	}
+
+	return selected
+}
+
+// writeManifest writes the manifest and syncs it, the files are synced when they are closed.
+func writeManifest(dir string, manifest Manifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(append(content, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// readManifest reads the manifest of the archive in dir.
+func readManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+
+	content, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error reading the archive manifest: %v", err.Error()))
+	}
+
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error reading the archive manifest: %v", err.Error()))
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,231 @@
+package archive
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// extensions are the file extensions of the archive formats.
+var extensions = map[string]string{
+	config.FormatNDJSON:  ".ndjson.gz",
+	config.FormatParquet: ".parquet",
+}
+
+// rowEncoder encodes rows to an archive file, Close finishes the file without closing it.
+type rowEncoder interface {
+	Write(rows []Row) error
+	Close() error
+}
+
+// fileWriter writes one archive file and hashes what it writes.
+type fileWriter struct {
+	file    *os.File
+	hash    hash.Hash
+	encoder rowEncoder
+	rows    int64
+}
+
+// newFileWriter creates the index-th file of the archive in dir, e.g. part-00000.ndjson.gz.
+func newFileWriter(dir string, index int, format string) (*fileWriter, error) {
+	extension, ok := extensions[format]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown archive format %q", format))
+	}
+
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("part-%05d%s", index, extension)))
+	if err != nil {
+		return nil, err
+	}
+
+	w := &fileWriter{file: f, hash: sha256.New()}
+	out := io.MultiWriter(f, w.hash)
+
+	switch format {
+	case config.FormatParquet:
+		w.encoder = &parquetEncoder{w: parquet.NewGenericWriter[Row](out, parquet.Compression(&parquet.Zstd))}
+	default:
+		gz := gzip.NewWriter(out)
+		w.encoder = &ndjsonEncoder{gz: gz, enc: json.NewEncoder(gz)}
+	}
+
+	return w, nil
+}
+
+func (w *fileWriter) Write(rows []Row) error {
+	if err := w.encoder.Write(rows); err != nil {
+		return errors.New(fmt.Sprintf("Error writing %v: %v", w.file.Name(), err.Error()))
+	}
+
+	w.rows += int64(len(rows))
+
+	return nil
+}
+
+// Close finishes, syncs and closes the file and returns its manifest entry.
+func (w *fileWriter) Close() (File, error) {
+	err := w.encoder.Close()
+	if err == nil {
+		err = w.file.Sync()
+	}
+
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return File{}, errors.New(fmt.Sprintf("Error writing %v: %v", w.file.Name(), err.Error()))
+	}
+
+	info, err := os.Stat(w.file.Name())
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{Name: filepath.Base(w.file.Name()), Rows: w.rows, Bytes: info.Size(), SHA256: hex.EncodeToString(w.hash.Sum(nil))}, nil
+}
+
+type ndjsonEncoder struct {
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func (n *ndjsonEncoder) Write(rows []Row) error {
+	for _, row := range rows {
+		if err := n.enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close writes the gzip footer.
+func (n *ndjsonEncoder) Close() error {
+	return n.gz.Close()
+}
+
+type parquetEncoder struct {
+	w *parquet.GenericWriter[Row]
+}
+
+func (p *parquetEncoder) Write(rows []Row) error {
+	_, err := p.w.Write(rows)
+	return err
+}
+
+// Close writes the parquet footer.
+func (p *parquetEncoder) Close() error {
+	return p.w.Close()
+}
+
+// verify checks that the file of the archive in dir has the size and SHA-256 of its manifest entry.
+func verify(dir string, file File) error {
+	if file.Name != filepath.Base(file.Name) {
+		return errors.New(fmt.Sprintf("archive file %q is outside the archive", file.Name))
+	}
+
+	f, err := os.Open(filepath.Join(dir, file.Name))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	if size != file.Bytes || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return errors.New(fmt.Sprintf("archive file %v does not match its checksum in the manifest", file.Name))
+	}
+
+	return nil
+}
+
+// readFile passes the rows of the archive file in dir to load in batches of at most batchSize.
+func readFile(dir string, file File, format string, load func(rows []Row) error) error {
+	f, err := os.Open(filepath.Join(dir, file.Name))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if format == config.FormatParquet {
+		return readParquet(f, load)
+	}
+
+	return readNDJSON(f, load)
+}
+
+func readNDJSON(r io.Reader, load func(rows []Row) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	batch := make([]Row, 0, batchSize)
+
+	for {
+		var row Row
+
+		err = dec.Decode(&row)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if batch = append(batch, row); len(batch) == batchSize {
+			if err = load(batch); err != nil {
+				return err
+			}
+
+			batch = batch[:0]
+		}
+	}
+
+	return load(batch)
+}
+
+func readParquet(f *os.File, load func(rows []Row) error) error {
+	reader := parquet.NewGenericReader[Row](f)
+	defer reader.Close()
+
+	batch := make([]Row, batchSize)
+
+	for {
+		n, err := reader.Read(batch)
+		if n > 0 {
+			if loadErr := load(batch[:n]); loadErr != nil {
+				return loadErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
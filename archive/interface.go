@@ -0,0 +1,14 @@
+package archive
+
+import (
+	"context"
+	"time"
+)
+
+// Archiver moves logins out of the database into archive files and loads archives back for audits.
+type Archiver interface {
+	// Archive writes the logins created in [from, to) to a new archive and deletes them from user_logins.
+	Archive(ctx context.Context, from, to time.Time) (Manifest, error)
+	// Restore verifies the archive in dir against its manifest and loads it into the new staging table.
+	Restore(ctx context.Context, dir, staging string) (Manifest, error)
+}
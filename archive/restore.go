@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"time"
+)
+
+// Restore checks every file against the manifest before anything is loaded, then creates the table with the columns
+// of user_logins, without its keys and indexes, and copies the archive into it in one transaction.
+func (a *archiver) Restore(ctx context.Context, dir, staging string) (Manifest, error) {
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return manifest, err
+	}
+
+	if staging == table {
+		return manifest, errors.New(fmt.Sprintf("archives are restored into a staging table, not %v", table))
+	}
+
+	if manifest.Table != table {
+		return manifest, errors.New(fmt.Sprintf("the archive is of table %q, not %v", manifest.Table, table))
+	}
+
+	if _, ok := extensions[manifest.Format]; !ok {
+		return manifest, errors.New(fmt.Sprintf("unknown archive format %q in the manifest", manifest.Format))
+	}
+
+	var rows int64
+	for _, file := range manifest.Files {
+		if err = verify(dir, file); err != nil {
+			return manifest, err
+		}
+
+		rows += file.Rows
+	}
+
+	if rows != manifest.Rows {
+		return manifest, errors.New(fmt.Sprintf("the files of the manifest hold %d logins but it lists %d", rows, manifest.Rows))
+	}
+
+	tx, err := a.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error restoring the archive: %v", err.Error()))
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (LIKE %s)", pq.QuoteIdentifier(staging), table)); err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error creating the staging table %v: %v", staging, err.Error()))
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(staging, columns...))
+	if err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error restoring the archive: %v", err.Error()))
+	}
+
+	for _, file := range manifest.Files {
+		var loaded int64
+
+		err = readFile(dir, file, manifest.Format, func(batch []Row) error {
+			for _, row := range batch {
+				_, err := stmt.ExecContext(ctx, row.ID, row.UserID, row.DeviceType, row.MaskedIP, row.MaskedDeviceID, row.Locale, row.AppVersion,
+					row.CreateDate, row.PerUserKey, row.Shredded, row.IPPseudonym)
+				if err != nil {
+					return err
+				}
+			}
+
+			loaded += int64(len(batch))
+
+			return nil
+		})
+		if err != nil {
+			return manifest, errors.New(fmt.Sprintf("Error restoring %v: %v", file.Name, err.Error()))
+		}
+
+		if loaded != file.Rows {
+			return manifest, errors.New(fmt.Sprintf("Error restoring %v: read %d logins but the manifest lists %d", file.Name, loaded, file.Rows))
+		}
+	}
+
+	// The copy is sent by the final Exec without arguments.
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error restoring the archive: %v", err.Error()))
+	}
+
+	if err = stmt.Close(); err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error restoring the archive: %v", err.Error()))
+	}
+
+	if err = tx.Commit(); err != nil {
+		return manifest, errors.New(fmt.Sprintf("Error restoring the archive: %v", err.Error()))
+	}
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Restored %d logins created in [%v, %v) from %v into %v", manifest.Rows,
+		manifest.From.Format(time.RFC3339), manifest.To.Format(time.RFC3339), dir, staging)}
+	a.logger.Log(&lm)
+
+	return manifest, nil
+}
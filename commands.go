@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/api/store"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/archive"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/keystore"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/migrations"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/partitions"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage:
+  dataops-takehome [flags]                          run the ETL
+  dataops-takehome [flags] erase <user_id>          shred a user's data key and mark their records as erased
+  dataops-takehome [flags] report app-versions [-from 2024-06-01] [-to 2024-06-08] [-min-version 2.0.0]
+  dataops-takehome [flags] config print [--redacted] print the effective settings as YAML
+  dataops-takehome [flags] migrate up|status        apply the pending schema migrations, or list them all
+  dataops-takehome [flags] migrate down [-steps 1]  revert the last applied migrations
+  dataops-takehome [flags] partitions [-dry-run]    create the next monthly partitions of user_logins and expire old ones
+  dataops-takehome [flags] archive -from 2023-05-01 -to 2023-06-01 [-format ndjson|parquet] [-dir archives]
+                                                    move the logins of the range to archive files and delete them
+  dataops-takehome [flags] restore [-table restored_<archive>] <archive dir>
+                                                    verify an archive and load it into a new staging table
+
+flags, each overriding the config file and the environment:
+`
+
+// runCommand runs a one-off command given on the command line.
+func runCommand(logger *log.CustomLogger, dbConn *sql.DB, cfg *config.Config, maskingPolicy model.MaskingPolicy, args []string) {
+	var err error
+
+	switch {
+	case args[0] == "erase" && len(args) == 2:
+		err = eraseUser(logger, dbConn, cfg.Masking.EncryptionSecret, maskingPolicy, args[1])
+	case args[0] == "report" && len(args) >= 2 && args[1] == "app-versions":
+		err = appVersionReport(dbConn, cfg.API.MinSupportedAppVersion, args[2:])
+	case args[0] == "migrate" && len(args) >= 2:
+		err = migrate(logger, dbConn, args[1], args[2:])
+	case args[0] == "partitions":
+		err = maintainPartitions(logger, dbConn, cfg.Partitions, args[1:])
+	case args[0] == "archive":
+		err = archiveLogins(logger, dbConn, cfg.Archive, args[1:])
+	case args[0] == "restore":
+		err = restoreArchive(logger, dbConn, cfg.Archive, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage+config.Usage())
+		os.Exit(2)
+	}
+
+	if err != nil {
+		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Command %v failed with error %v", args[0], err.Error())}
+		logger.Log(&lm)
+
+		os.Exit(1)
+	}
+}
+
+// runConfigCommand prints the effective settings, `config print --redacted` hides the secrets.
+func runConfigCommand(logger *log.CustomLogger, cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	redacted := flags.Bool("redacted", false, "replace secrets with "+config.Redacted)
+
+	if len(args) < 2 || args[1] != "print" || flags.Parse(args[2:]) != nil {
+		fmt.Fprint(os.Stderr, usage+config.Usage())
+		os.Exit(2)
+	}
+
+	if err := cfg.Print(os.Stdout, *redacted); err != nil {
+		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Command config failed with error %v", err.Error())}
+		logger.Log(&lm)
+
+		os.Exit(1)
+	}
+}
+
+// migrate applies, reverts or lists the schema migrations.
+func migrate(logger *log.CustomLogger, dbConn *sql.DB, action string, args []string) error {
+	migrator := migrations.New(logger, dbConn)
+	ctx := context.Background()
+
+	switch action {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Applied %d migrations, the schema is up to date.", len(applied))}
+		logger.Log(&lm)
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+
+		if *steps < 1 {
+			return errors.New("-steps must be at least 1")
+		}
+
+		reverted, err := migrator.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+
+		lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Reverted %d migrations.", len(reverted))}
+		logger.Log(&lm)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT\t")
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(tw, "%d\t%v\t%v\t\n", status.Version, status.Name, appliedAt)
+		}
+
+		return tw.Flush()
+	default:
+		return errors.New(fmt.Sprintf("unknown migrate action %q, use up, down or status", action))
+	}
+
+	return nil
+}
+
+// eraseUser destroys the data key and tokens of the user and shreds their records as the masking policy requires.
+func eraseUser(logger *log.CustomLogger, dbConn *sql.DB, encryptionKey string, maskingPolicy model.MaskingPolicy, userID string) error {
+	shredded, err := keystore.New(dbConn, encryptionKey, maskingPolicy).Erase(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Erased user %v, %v records shredded.", userID, shredded)}
+	logger.Log(&lm)
+
+	return nil
+}
+
+// appVersionReport prints the app version adoption per device type, versions below the minimum supported version are
+// marked with a '!'. The range defaults to the last 7 days and the minimum to the configured minimum supported version.
+func appVersionReport(dbConn *sql.DB, minSupportedAppVersion string, args []string) error {
+	flags := flag.NewFlagSet("report app-versions", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the range, YYYY-MM-DD or RFC 3339")
+	to := flags.String("to", "", "end of the range, YYYY-MM-DD or RFC 3339")
+	minVersion := flags.String("min-version", minSupportedAppVersion, "minimum supported app version")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := model.StatsFilter{To: time.Now().UTC()}
+	if *to != "" {
+		t, err := parseDate(*to)
+		if err != nil {
+			return err
+		}
+
+		filter.To = t
+	}
+
+	filter.From = filter.To.Add(-7 * 24 * time.Hour)
+	if *from != "" {
+		t, err := parseDate(*from)
+		if err != nil {
+			return err
+		}
+
+		filter.From = t
+	}
+
+	var minSupported *model.Version
+	if *minVersion != "" {
+		v, err := model.ParseVersion(*minVersion)
+		if err != nil {
+			return err
+		}
+
+		minSupported = &v
+	}
+
+	report, err := store.NewStats(dbConn).AppVersions(context.Background(), &filter, minSupported)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("App versions from %v to %v, %d logins by %d users", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339), report.Logins, report.Users)
+	if report.MinSupportedVersion != "" {
+		fmt.Printf(", minimum supported version %v", report.MinSupportedVersion)
+	}
+
+	fmt.Println()
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tDEVICE TYPE\tLOGINS\tUSERS\tSHARE OF USERS\t")
+
+	for _, version := range report.Versions {
+		marker := ""
+		if version.Unsupported {
+			marker = " !"
+		}
+
+		fmt.Fprintf(tw, "%v%v\tall\t%d\t%d\t%.1f%%\t\n", version.Version, marker, version.Logins, version.Users, version.UserShare*100)
+		for _, device := range version.DeviceTypes {
+			fmt.Fprintf(tw, "\t%v\t%d\t%d\t%.1f%%\t\n", device.DeviceType, device.Logins, device.Users, device.UserShare*100)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// parseDate parses a YYYY-MM-DD date or an RFC 3339 timestamp.
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// maintainPartitions runs the partition maintenance once and prints the changes, `-dry-run` only prints them.
+func maintainPartitions(logger *log.CustomLogger, dbConn *sql.DB, settings config.Partitions, args []string) error {
+	flags := flag.NewFlagSet("partitions", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", settings.DryRun, "print the changes without making them")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// The changes made before and after a failed one are printed as well.
+	report, err := partitions.New(logger, dbConn, settings).Maintain(context.Background(), *dryRun)
+	if err != nil && len(report.Changes) == 0 {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tPARTITION\tFROM\tTO\tROWS\t")
+
+	for _, change := range report.Changes {
+		action := change.Action
+		if report.DryRun {
+			action += " (dry run)"
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%d\t\n", action, change.Partition, change.From.Format(time.DateOnly), change.To.Format(time.DateOnly), change.Rows)
+	}
+
+	if flushErr := tw.Flush(); flushErr != nil {
+		return flushErr
+	}
+
+	fmt.Printf("%d changes, %d logins outside every monthly partition.\n", len(report.Changes), report.DefaultRows)
+
+	return err
+}
+
+// archiveLogins moves the logins created in [-from, -to) to a new archive and prints its manifest.
+func archiveLogins(logger *log.CustomLogger, dbConn *sql.DB, settings config.Archive, args []string) error {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the range, YYYY-MM-DD or RFC 3339")
+	to := flags.String("to", "", "end of the range, excluded, YYYY-MM-DD or RFC 3339")
+	flags.StringVar(&settings.Format, "format", settings.Format, "ndjson or parquet")
+	flags.StringVar(&settings.Dir, "dir", settings.Dir, "directory of the archives")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" {
+		return errors.New("-from and -to are required")
+	}
+
+	if settings.Format != config.FormatNDJSON && settings.Format != config.FormatParquet {
+		return errors.New(fmt.Sprintf("-format must be ndjson or parquet, got %q", settings.Format))
+	}
+
+	fromTime, err := parseDate(*from)
+	if err != nil {
+		return err
+	}
+
+	toTime, err := parseDate(*to)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := archive.New(logger, dbConn, settings).Archive(context.Background(), fromTime, toTime)
+	if err != nil {
+		return err
+	}
+
+	return printManifest(manifest)
+}
+
+// restoreArchive loads the archive in the directory given into a new table and prints its manifest.
+func restoreArchive(logger *log.CustomLogger, dbConn *sql.DB, settings config.Archive, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	table := flags.String("table", "", "staging table created for the archive, restored_<archive> when empty")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("restore needs the directory of one archive")
+	}
+
+	dir := flags.Arg(0)
+	if *table == "" {
+		*table = "restored_" + filepath.Base(filepath.Clean(dir))
+	}
+
+	manifest, err := archive.New(logger, dbConn, settings).Restore(context.Background(), dir, *table)
+	if err != nil {
+		return err
+	}
+
+	return printManifest(manifest)
+}
+
+// printManifest prints the files of an archive with their logins and checksums.
+func printManifest(manifest archive.Manifest) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tROWS\tBYTES\tSHA256\t")
+
+	for _, file := range manifest.Files {
+		fmt.Fprintf(tw, "%v\t%d\t%d\t%v\t\n", file.Name, file.Rows, file.Bytes, file.SHA256)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d logins created in [%v, %v).\n", manifest.Rows, manifest.From.Format(time.RFC3339), manifest.To.Format(time.RFC3339))
+
+	return nil
+}
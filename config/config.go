@@ -0,0 +1,171 @@
+package config
+
+import "time"
+
+// Service is the binary the configuration is loaded for, it decides which settings are required.
+type Service string
+
+const (
+	ETL Service = "etl"
+	API Service = "api"
+)
+
+// Config holds every setting of the ETL and the API. Each field is read from the config file by its yaml path, from
+// the env variable in its env tag and from the flag named after its yaml path, e.g. -db.host.
+type Config struct {
+	DB         DB         `yaml:"db"`
+	SQS        SQS        `yaml:"sqs"`
+	AWS        AWS        `yaml:"aws"`
+	Masking    Masking    `yaml:"masking"`
+	Pipeline   Pipeline   `yaml:"pipeline"`
+	API        APIServer  `yaml:"api"`
+	Startup    Startup    `yaml:"startup"`
+	Partitions Partitions `yaml:"partitions"`
+	Archive    Archive    `yaml:"archive"`
+	Sinks      Sinks      `yaml:"sinks"`
+}
+
+// DB is the Postgres connection, either the DSN or the structured options, and its pool.
+type DB struct {
+	DSN              string        `yaml:"dsn" env:"DATABASE_URL" secret:"true" help:"full connection string, replaces the structured options"`
+	User             string        `yaml:"user" env:"DB_USER" help:"database user"`
+	Password         string        `yaml:"password" env:"DB_PASS" secret:"true" help:"database password"`
+	Host             string        `yaml:"host" env:"DB_HOST" help:"database host"`
+	Port             int           `yaml:"port" env:"DB_PORT" help:"database port"`
+	Name             string        `yaml:"name" env:"DB_NAME" help:"database name"`
+	Driver           string        `yaml:"driver" env:"DRIVER_NAME" help:"database/sql driver name"`
+	SSLMode          string        `yaml:"sslmode" env:"DB_SSLMODE" help:"disable, require, verify-ca or verify-full"`
+	SSLRootCert      string        `yaml:"sslrootcert" env:"DB_SSLROOTCERT" help:"CA certificate file verifying the server"`
+	SSLCert          string        `yaml:"sslcert" env:"DB_SSLCERT" help:"client certificate file"`
+	SSLKey           string        `yaml:"sslkey" env:"DB_SSLKEY" help:"client key file"`
+	ApplicationName  string        `yaml:"application_name" env:"DB_APPLICATION_NAME" help:"application_name shown in pg_stat_activity"`
+	StatementTimeout time.Duration `yaml:"statement_timeout" env:"DB_STATEMENT_TIMEOUT" help:"statements running longer are canceled, 0 for none"`
+	ConnectTimeout   time.Duration `yaml:"connect_timeout" env:"DB_CONNECT_TIMEOUT" help:"timeout of opening a connection, 0 for none"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" help:"open connections at most, 0 for unlimited"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" help:"idle connections kept at most"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" help:"connections are closed after, 0 for never"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" help:"idle connections are closed after, 0 for never"`
+
+	ConnectMaxAttempts   int           `yaml:"connect_max_attempts" env:"DB_CONNECT_MAX_ATTEMPTS" help:"pings before opening the database fails"`
+	ConnectRetryInterval time.Duration `yaml:"connect_retry_interval" env:"DB_CONNECT_RETRY_INTERVAL" help:"first wait between pings, doubled after each one"`
+}
+
+// SQS is the queue the ETL polls.
+type SQS struct {
+	Endpoint    string `yaml:"endpoint" env:"SQS_ENDPOINT" help:"URL of the queue"`
+	MaxMessages int32  `yaml:"max_messages" env:"MAX_MESSAGES" help:"messages asked for per poll"`
+	MaxWaitTime int32  `yaml:"max_wait_time" env:"MAX_WAIT_TIME" help:"seconds a poll waits for messages"`
+}
+
+// Credential sources of AWS.
+const (
+	CredentialsDefault    = "default"
+	CredentialsStatic     = "static"
+	CredentialsProfile    = "profile"
+	CredentialsAssumeRole = "assume_role"
+)
+
+// AWS is how the ETL signs in to AWS. The credential source picks the SDK default chain, the static keys, a shared
+// profile, or a role assumed with the default chain.
+type AWS struct {
+	Region           string `yaml:"region" env:"AWS_REGION" help:"region of the queue, from the profile when empty"`
+	Endpoint         string `yaml:"endpoint" env:"AWS_ENDPOINT_URL" help:"endpoint override, e.g. http://localhost:4566 for LocalStack"`
+	CredentialSource string `yaml:"credential_source" env:"AWS_CREDENTIAL_SOURCE" help:"default, static, profile or assume_role"`
+	AccessKeyID      string `yaml:"access_key_id" env:"AWS_ACCESS_KEY_ID" help:"access key of the static credentials"`
+	SecretAccessKey  string `yaml:"secret_access_key" env:"AWS_SECRET_ACCESS_KEY" secret:"true" help:"secret key of the static credentials"`
+	SessionToken     string `yaml:"session_token" env:"AWS_SESSION_TOKEN" secret:"true" help:"session token of the static credentials"`
+	Profile          string `yaml:"profile" env:"AWS_PROFILE" help:"shared config profile"`
+	RoleARN          string `yaml:"role_arn" env:"AWS_ASSUME_ROLE_ARN" help:"role to assume"`
+	ExternalID       string `yaml:"external_id" env:"AWS_ASSUME_ROLE_EXTERNAL_ID" help:"external ID required by the role's trust policy"`
+	RoleSessionName  string `yaml:"role_session_name" env:"AWS_ROLE_SESSION_NAME" help:"session name of the assumed role"`
+}
+
+// Masking is how PII is masked before it is stored.
+type Masking struct {
+	EncryptionSecret string `yaml:"encryption_secret" env:"ENCRYPTION_SECRET" secret:"true" help:"AES key, 16, 24 or 32 bytes"`
+	PerUserKeys      bool   `yaml:"per_user_keys" env:"PER_USER_KEYS" help:"encrypt every user with their own data key"`
+	PolicyFile       string `yaml:"policy_file" env:"MASKING_POLICY_FILE" help:"masking policy JSON file, the default policy when empty"`
+}
+
+// Pipeline is how the ETL worker backs off and how it is operated.
+type Pipeline struct {
+	Port                      int    `yaml:"port" env:"PORT" help:"port of the metrics, health and control server"`
+	AdminToken                string `yaml:"admin_token" env:"ETL_ADMIN_TOKEN" secret:"true" help:"bearer token of the control plane, disabled when empty"`
+	MaxNoResponses            int    `yaml:"max_no_responses" env:"MAX_NO_RESPONSES" help:"empty polls before backing off"`
+	MaxConsecutiveNoResponses int    `yaml:"max_consecutive_no_responses" env:"MAX_CONSECUTIVE_NO_RESPONSES" help:"empty polls before the worker stops"`
+}
+
+// APIServer is the API server.
+type APIServer struct {
+	Port                   int    `yaml:"port" env:"PORT" help:"port of the API"`
+	APIKeysFile            string `yaml:"api_keys_file" env:"AUTH_API_KEYS_FILE" help:"JSON file of hashed API keys"`
+	JWTSecret              string `yaml:"jwt_secret" env:"AUTH_JWT_SECRET" secret:"true" help:"HS256 secret of bearer JWTs"`
+	MinSupportedAppVersion string `yaml:"min_supported_app_version" env:"MIN_SUPPORTED_APP_VERSION" help:"versions below are flagged in the app version report"`
+}
+
+// Startup is how long the services wait for their dependencies.
+type Startup struct {
+	MaxAttempts   int           `yaml:"max_attempts" env:"STARTUP_MAX_ATTEMPTS" help:"readiness checks before giving up"`
+	RetryInterval time.Duration `yaml:"retry_interval" env:"STARTUP_RETRY_INTERVAL" help:"wait between readiness checks"`
+}
+
+// Actions taken on the partitions of expired months.
+const (
+	ExpiredDrop   = "drop"
+	ExpiredDetach = "detach"
+)
+
+// Partitions is how the ETL maintains the monthly partitions of user_logins: it creates the partitions of the next
+// months and drops or detaches the ones of months past the retention.
+type Partitions struct {
+	Interval        time.Duration `yaml:"interval" env:"PARTITION_INTERVAL" help:"wait between maintenance runs, 0 disables the job"`
+	PremakeMonths   int           `yaml:"premake_months" env:"PARTITION_PREMAKE_MONTHS" help:"months after the current one that have a partition"`
+	RetentionMonths int           `yaml:"retention_months" env:"PARTITION_RETENTION_MONTHS" help:"months before the current one that are kept, 0 keeps every month"`
+	ExpiredAction   string        `yaml:"expired_action" env:"PARTITION_EXPIRED_ACTION" help:"drop or detach the partitions of expired months"`
+	DryRun          bool          `yaml:"dry_run" env:"PARTITION_DRY_RUN" help:"only log what the maintenance would change"`
+}
+
+// Formats of the archive and sink files.
+const (
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// Archive is where and how logins are archived before they are deleted from the database.
+type Archive struct {
+	Dir         string `yaml:"dir" env:"ARCHIVE_DIR" help:"directory, e.g. a mounted volume, holding one directory per archive"`
+	Format      string `yaml:"format" env:"ARCHIVE_FORMAT" help:"ndjson, compressed with gzip, or parquet"`
+	RowsPerFile int    `yaml:"rows_per_file" env:"ARCHIVE_ROWS_PER_FILE" help:"logins per archive file at most"`
+}
+
+// Sinks that must load a batch before its messages are deleted from the queue.
+const (
+	AckAll     = "all"
+	AckPrimary = "primary"
+)
+
+// Sinks are where the ETL loads the masked records. Postgres is the primary sink, the file sink writes the same
+// records to files rolled every hour.
+type Sinks struct {
+	FileDir    string `yaml:"file_dir" env:"SINK_FILE_DIR" help:"directory of the file sink, disabled when empty"`
+	FileFormat string `yaml:"file_format" env:"SINK_FILE_FORMAT" help:"ndjson, compressed with gzip, or parquet"`
+	Ack        string `yaml:"ack" env:"SINK_ACK" help:"all or primary, the sinks that must load a batch before its messages are deleted"`
+}
+
+// Default returns the settings used when neither the file, the environment nor a flag sets them.
+func Default() Config {
+	return Config{
+		DB: DB{Host: "localhost", Port: 5432, Driver: "postgres", SSLMode: "require", ConnectTimeout: 5 * time.Second,
+			MaxOpenConns: 20, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute, ConnMaxIdleTime: 5 * time.Minute,
+			ConnectMaxAttempts: 5, ConnectRetryInterval: time.Second},
+		SQS:        SQS{MaxMessages: 10, MaxWaitTime: 3},
+		AWS:        AWS{CredentialSource: CredentialsDefault, RoleSessionName: "dataops-takehome"},
+		Pipeline:   Pipeline{Port: 9090, MaxNoResponses: 5, MaxConsecutiveNoResponses: 15},
+		API:        APIServer{Port: 8080},
+		Startup:    Startup{MaxAttempts: 10, RetryInterval: 3 * time.Second},
+		Partitions: Partitions{Interval: time.Hour, PremakeMonths: 3, ExpiredAction: ExpiredDetach},
+		Archive:    Archive{Dir: "archives", Format: FormatNDJSON, RowsPerFile: 100_000},
+		Sinks:      Sinks{FileFormat: FormatNDJSON, Ack: AckPrimary},
+	}
+}
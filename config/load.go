@@ -0,0 +1,189 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors is every problem found while loading and validating the configuration.
+type Errors []string
+
+func (e Errors) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e, "\n  - "))
+}
+
+// field is a setting of the Config, addressed by its yaml path.
+type field struct {
+	path   string
+	env    string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+// Load reads the configuration for the service from the defaults, the YAML file given by -config or CONFIG_FILE, the
+// environment and the flags in args, each overriding the previous one. It returns the arguments left after the flags,
+// e.g. a command, whose settings are the only ones validated besides the common ones, and all errors at once as
+// Errors, or flag.ErrHelp for -h.
+func Load(service Service, args []string) (*Config, []string, error) {
+	cfg := Default()
+	cfg.DB.ApplicationName = "dataops-takehome-" + string(service)
+	fields := cfg.fields()
+
+	// Flags are parsed first to find the config file, they are applied last.
+	flags := flag.NewFlagSet(string(service), flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML config file")
+	flagValues := make(map[string]string)
+
+	for _, f := range fields {
+		path := f.path
+		flags.Func(path, f.help, func(value string) error {
+			flagValues[path] = value
+			return nil
+		})
+	}
+
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, Errors{err.Error()}
+	}
+
+	var errs Errors
+
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := os.LookupEnv(f.env); ok && f.env != "" {
+			if err := set(f.value, value); err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v", f.env, err.Error()))
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := flagValues[f.path]; ok {
+			if err := set(f.value, value); err != nil {
+				errs = append(errs, fmt.Sprintf("-%v: %v", f.path, err.Error()))
+			}
+		}
+	}
+
+	var command string
+	if flags.NArg() > 0 {
+		command = flags.Arg(0)
+	}
+
+	errs = append(errs, cfg.validate(service, command)...)
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	return &cfg, flags.Args(), nil
+}
+
+// Usage lists the flags with the env variable each one overrides.
+func Usage() string {
+	cfg := Default()
+
+	var usage strings.Builder
+	usage.WriteString("  -config string\n    \tYAML config file (env CONFIG_FILE)\n")
+
+	for _, f := range cfg.fields() {
+		usage.WriteString(fmt.Sprintf("  -%s %s\n    \t%s (env %s)\n", f.path, strings.ToLower(f.value.Type().Name()), f.help, f.env))
+	}
+
+	return usage.String()
+}
+
+// readFile reads the YAML file over the current settings, unknown keys are errors so that typos do not go unnoticed.
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("reading config file: %v", err.Error()))
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	if err = decoder.Decode(c); err != nil && err != io.EOF {
+		return errors.New(fmt.Sprintf("parsing config file %v: %v", path, err.Error()))
+	}
+
+	return nil
+}
+
+// fields lists the settings of the Config in declaration order.
+func (c *Config) fields() []field {
+	var fields []field
+
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionName := sections.Type().Field(i).Tag.Get("yaml")
+
+		for j := 0; j < section.NumField(); j++ {
+			tag := section.Type().Field(j).Tag
+
+			fields = append(fields, field{
+				path:   sectionName + "." + tag.Get("yaml"),
+				env:    tag.Get("env"),
+				help:   tag.Get("help"),
+				secret: tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
+	}
+
+	return fields
+}
+
+// set parses the text into the setting.
+func set(value reflect.Value, text string) error {
+	text = strings.TrimSpace(text)
+
+	switch value.Interface().(type) {
+	case string:
+		value.SetString(text)
+	case bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%q is not a boolean", text))
+		}
+
+		value.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%q is not a duration such as 3s", text))
+		}
+
+		value.SetInt(int64(d))
+	case int, int32:
+		n, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return errors.New(fmt.Sprintf("%q is not an integer", text))
+		}
+
+		value.SetInt(n)
+	default:
+		return errors.New(fmt.Sprintf("unsupported setting type %v", value.Type()))
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+// Redacted replaces secret values that are set.
+const Redacted = "<redacted>"
+
+// Print writes the settings as a YAML config file. With redacted, secrets are replaced by Redacted.
+func (c *Config) Print(w io.Writer, redacted bool) error {
+	out := *c
+
+	if redacted {
+		for _, f := range out.fields() {
+			if f.secret && f.value.String() != "" {
+				f.value.SetString(Redacted)
+			}
+		}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(out); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// validate checks the settings the service uses and returns every problem found. The ETL only checks the settings of
+// the command it runs, the empty command being the pipeline itself, so e.g. `migrate` needs no queue.
+func (c *Config) validate(service Service, command string) Errors {
+	var errs Errors
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	// Both services read the database with the same keys.
+	errs = append(errs, c.DB.validate()...)
+
+	// Only the pipeline and the API mask or unmask records, the one-off commands read and write them as they are stored.
+	if service == API || command == "" {
+		if err := model.CheckKey(c.Masking.EncryptionSecret); err != nil {
+			errs = append(errs, fmt.Sprintf("masking.encryption_secret (ENCRYPTION_SECRET): %v", err.Error()))
+		}
+	}
+
+	check(c.Startup.MaxAttempts >= 1, "startup.max_attempts (STARTUP_MAX_ATTEMPTS) must be at least 1, got %d", c.Startup.MaxAttempts)
+	check(c.Startup.RetryInterval > 0, "startup.retry_interval (STARTUP_RETRY_INTERVAL) must be positive, got %v", c.Startup.RetryInterval)
+
+	if c.API.MinSupportedAppVersion != "" {
+		if _, err := model.ParseVersion(c.API.MinSupportedAppVersion); err != nil {
+			errs = append(errs, fmt.Sprintf("api.min_supported_app_version (MIN_SUPPORTED_APP_VERSION): %v", err.Error()))
+		}
+	}
+
+	switch {
+	case service == ETL && command == "":
+		check(c.SQS.Endpoint != "", "sqs.endpoint (SQS_ENDPOINT) is required")
+		check(c.SQS.MaxMessages >= 1, "sqs.max_messages (MAX_MESSAGES) must be at least 1, got %d", c.SQS.MaxMessages)
+		check(c.SQS.MaxWaitTime >= 0 && c.SQS.MaxWaitTime <= 20, "sqs.max_wait_time (MAX_WAIT_TIME) must be between 0 and 20, got %d", c.SQS.MaxWaitTime)
+		errs = append(errs, c.AWS.validate()...)
+		check(validPort(c.Pipeline.Port), "pipeline.port (PORT) must be between 1 and 65535, got %d", c.Pipeline.Port)
+		check(c.Pipeline.MaxNoResponses >= 1, "pipeline.max_no_responses (MAX_NO_RESPONSES) must be at least 1, got %d", c.Pipeline.MaxNoResponses)
+		check(c.Pipeline.MaxConsecutiveNoResponses >= c.Pipeline.MaxNoResponses,
+			"pipeline.max_consecutive_no_responses (MAX_CONSECUTIVE_NO_RESPONSES) must be at least max_no_responses, got %d", c.Pipeline.MaxConsecutiveNoResponses)
+		errs = append(errs, c.Partitions.validate()...)
+		check(c.Sinks.FileFormat == FormatNDJSON || c.Sinks.FileFormat == FormatParquet, "sinks.file_format (SINK_FILE_FORMAT) must be ndjson or parquet, got %q", c.Sinks.FileFormat)
+		check(c.Sinks.Ack == AckAll || c.Sinks.Ack == AckPrimary, "sinks.ack (SINK_ACK) must be all or primary, got %q", c.Sinks.Ack)
+	case service == ETL && command == "partitions":
+		errs = append(errs, c.Partitions.validate()...)
+	case service == ETL && (command == "archive" || command == "restore"):
+		check(c.Archive.Dir != "", "archive.dir (ARCHIVE_DIR) is required")
+		check(c.Archive.Format == FormatNDJSON || c.Archive.Format == FormatParquet, "archive.format (ARCHIVE_FORMAT) must be ndjson or parquet, got %q", c.Archive.Format)
+		check(c.Archive.RowsPerFile >= 1, "archive.rows_per_file (ARCHIVE_ROWS_PER_FILE) must be at least 1, got %d", c.Archive.RowsPerFile)
+	case service == API:
+		check(validPort(c.API.Port), "api.port (PORT) must be between 1 and 65535, got %d", c.API.Port)
+	}
+
+	return errs
+}
+
+// validate checks the connection options, unless the DSN replaces them, and the pool limits.
+func (d *DB) validate() Errors {
+	var errs Errors
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(d.Driver != "", "db.driver (DRIVER_NAME) is required")
+
+	if d.DSN == "" {
+		check(d.User != "", "db.user (DB_USER) is required")
+		check(d.Host != "", "db.host (DB_HOST) is required")
+		check(d.Name != "", "db.name (DB_NAME) is required")
+		check(validPort(d.Port), "db.port (DB_PORT) must be between 1 and 65535, got %d", d.Port)
+
+		switch d.SSLMode {
+		case "disable", "require", "verify-ca", "verify-full":
+		default:
+			errs = append(errs, fmt.Sprintf("db.sslmode (DB_SSLMODE) must be disable, require, verify-ca or verify-full, got %q", d.SSLMode))
+		}
+
+		check((d.SSLCert == "") == (d.SSLKey == ""), "db.sslcert (DB_SSLCERT) and db.sslkey (DB_SSLKEY) must be set together")
+
+		for _, file := range []struct{ name, path string }{{"db.sslrootcert (DB_SSLROOTCERT)", d.SSLRootCert}, {"db.sslcert (DB_SSLCERT)", d.SSLCert}, {"db.sslkey (DB_SSLKEY)", d.SSLKey}} {
+			if file.path != "" {
+				_, err := os.Stat(file.path)
+				check(err == nil, "%v: %v", file.name, err)
+			}
+		}
+
+		check(d.StatementTimeout >= 0, "db.statement_timeout (DB_STATEMENT_TIMEOUT) must not be negative, got %v", d.StatementTimeout)
+		check(d.ConnectTimeout >= 0, "db.connect_timeout (DB_CONNECT_TIMEOUT) must not be negative, got %v", d.ConnectTimeout)
+	}
+
+	check(d.MaxOpenConns >= 0, "db.max_open_conns (DB_MAX_OPEN_CONNS) must not be negative, got %d", d.MaxOpenConns)
+	check(d.MaxIdleConns >= 0, "db.max_idle_conns (DB_MAX_IDLE_CONNS) must not be negative, got %d", d.MaxIdleConns)
+	check(d.MaxOpenConns == 0 || d.MaxIdleConns <= d.MaxOpenConns, "db.max_idle_conns (DB_MAX_IDLE_CONNS) must not exceed db.max_open_conns, got %d > %d", d.MaxIdleConns, d.MaxOpenConns)
+	check(d.ConnMaxLifetime >= 0, "db.conn_max_lifetime (DB_CONN_MAX_LIFETIME) must not be negative, got %v", d.ConnMaxLifetime)
+	check(d.ConnMaxIdleTime >= 0, "db.conn_max_idle_time (DB_CONN_MAX_IDLE_TIME) must not be negative, got %v", d.ConnMaxIdleTime)
+	check(d.ConnectMaxAttempts >= 1, "db.connect_max_attempts (DB_CONNECT_MAX_ATTEMPTS) must be at least 1, got %d", d.ConnectMaxAttempts)
+	check(d.ConnectRetryInterval > 0, "db.connect_retry_interval (DB_CONNECT_RETRY_INTERVAL) must be positive, got %v", d.ConnectRetryInterval)
+
+	return errs
+}
+
+// validate checks that the settings of the credential source are given.
+func (a *AWS) validate() Errors {
+	var errs Errors
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	switch a.CredentialSource {
+	case CredentialsDefault:
+	case CredentialsStatic:
+		check(a.AccessKeyID != "", "aws.access_key_id (AWS_ACCESS_KEY_ID) is required for static credentials")
+		check(a.SecretAccessKey != "", "aws.secret_access_key (AWS_SECRET_ACCESS_KEY) is required for static credentials")
+	case CredentialsProfile:
+		check(a.Profile != "", "aws.profile (AWS_PROFILE) is required for profile credentials")
+	case CredentialsAssumeRole:
+		check(strings.HasPrefix(a.RoleARN, "arn:"), "aws.role_arn (AWS_ASSUME_ROLE_ARN) must be a role ARN to assume a role, got %q", a.RoleARN)
+		check(a.RoleSessionName != "", "aws.role_session_name (AWS_ROLE_SESSION_NAME) is required to assume a role")
+	default:
+		errs = append(errs, fmt.Sprintf("aws.credential_source (AWS_CREDENTIAL_SOURCE) must be default, static, profile or assume_role, got %q", a.CredentialSource))
+	}
+
+	// A profile can name the region, otherwise it must be set.
+	check(a.Region != "" || a.CredentialSource == CredentialsProfile, "aws.region (AWS_REGION) is required")
+
+	if a.Endpoint != "" {
+		endpoint, err := url.Parse(a.Endpoint)
+		check(err == nil && endpoint.Scheme != "" && endpoint.Host != "", "aws.endpoint (AWS_ENDPOINT_URL) must be a URL such as http://localhost:4566, got %q", a.Endpoint)
+	}
+
+	return errs
+}
+
+// validate checks the maintenance schedule and what happens to expired partitions.
+func (p *Partitions) validate() Errors {
+	var errs Errors
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(p.Interval >= 0, "partitions.interval (PARTITION_INTERVAL) must not be negative, got %v", p.Interval)
+	check(p.PremakeMonths >= 0, "partitions.premake_months (PARTITION_PREMAKE_MONTHS) must not be negative, got %d", p.PremakeMonths)
+	check(p.RetentionMonths >= 0, "partitions.retention_months (PARTITION_RETENTION_MONTHS) must not be negative, got %d", p.RetentionMonths)
+	check(p.ExpiredAction == ExpiredDrop || p.ExpiredAction == ExpiredDetach,
+		"partitions.expired_action (PARTITION_EXPIRED_ACTION) must be drop or detach, got %q", p.ExpiredAction)
+
+	return errs
+}
+
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
@@ -1,37 +1,39 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
-	"os"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+// maxRetryInterval caps the doubling wait between connection attempts.
+const maxRetryInterval = 30 * time.Second
+
 type dbConn struct {
 	logger *log.CustomLogger
+	config config.DB
 }
 
 // New returns a new instance of SQLDatabase interface by creating and returning a pointer to dbConn struct.
-func New(logger *log.CustomLogger) SQLDatabase {
+func New(logger *log.CustomLogger, dbConfig config.DB) SQLDatabase {
 	return &dbConn{
 		logger: logger,
+		config: dbConfig,
 	}
 }
 
-// Open initializes and opens a connection to the database using environment variables for configuration.
+// Open opens the connection pool with the given configuration and pings the database until it answers, waiting
+// twice as long after every failed attempt.
 func (dbo *dbConn) Open() (*sql.DB, error) {
-	dbUser := os.Getenv("DB_USER")
-	dbPass := os.Getenv("DB_PASS")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
-	dbDriver := os.Getenv("DRIVER_NAME")
-
-	// Create the connection string using the retrieved environment variables.
-	connectionStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUser, dbPass, dbHost, dbPort, dbName)
-
 	// Initialize DB connection
-	db, err := sql.Open(dbDriver, connectionStr)
+	db, err := sql.Open(dbo.config.Driver, dbo.dsn())
 	if err != nil {
 		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Database initilization failed with error %v", err.Error())}
 		dbo.logger.Log(&lm)
@@ -39,5 +41,85 @@ func (dbo *dbConn) Open() (*sql.DB, error) {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(dbo.config.MaxOpenConns)
+	db.SetMaxIdleConns(dbo.config.MaxIdleConns)
+	db.SetConnMaxLifetime(dbo.config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(dbo.config.ConnMaxIdleTime)
+
+	if err = dbo.connect(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
 	return db, nil
 }
+
+// connect pings the database at most ConnectMaxAttempts times.
+func (dbo *dbConn) connect(db *sql.DB) error {
+	interval := dbo.config.ConnectRetryInterval
+
+	var err error
+	for attempt := 1; attempt <= dbo.config.ConnectMaxAttempts; attempt++ {
+		if err = dbo.ping(db); err == nil {
+			return nil
+		}
+
+		lm := log.Message{Level: "WARN", ErrorMessage: fmt.Sprintf("Connecting to the database failed (attempt %d of %d): %v", attempt, dbo.config.ConnectMaxAttempts, err.Error())}
+		dbo.logger.Log(&lm)
+
+		if attempt < dbo.config.ConnectMaxAttempts {
+			time.Sleep(interval)
+			interval = min(2*interval, maxRetryInterval)
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Error connecting to the database after %d attempts: %v", dbo.config.ConnectMaxAttempts, err.Error()))
+}
+
+func (dbo *dbConn) ping(db *sql.DB) error {
+	ctx := context.Background()
+	if dbo.config.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dbo.config.ConnectTimeout)
+		defer cancel()
+	}
+
+	return db.PingContext(ctx)
+}
+
+// dsn returns the configured DSN, or builds one from the structured options.
+func (dbo *dbConn) dsn() string {
+	if dbo.config.DSN != "" {
+		return dbo.config.DSN
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", dbo.config.SSLMode)
+
+	options := map[string]string{"sslrootcert": dbo.config.SSLRootCert, "sslcert": dbo.config.SSLCert, "sslkey": dbo.config.SSLKey, "application_name": dbo.config.ApplicationName}
+	for key, value := range options {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	// Parameters the driver does not know, such as statement_timeout in milliseconds, are set on every connection.
+	if dbo.config.StatementTimeout > 0 {
+		query.Set("statement_timeout", strconv.FormatInt(dbo.config.StatementTimeout.Milliseconds(), 10))
+	}
+
+	// connect_timeout is in whole seconds.
+	if dbo.config.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(max(1, int(dbo.config.ConnectTimeout.Seconds()))))
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(dbo.config.User, dbo.config.Password),
+		Host:     net.JoinHostPort(dbo.config.Host, strconv.Itoa(dbo.config.Port)),
+		Path:     "/" + dbo.config.Name,
+		RawQuery: query.Encode(),
+	}
+
+	return dsn.String()
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/internal/metrics"
+	"sync"
+)
+
+// RegisterPoolMetrics exposes the connection pool stats of db on every scrape of the registry.
+func RegisterPoolMetrics(registry *metrics.Registry, db *sql.DB) {
+	maxOpen := registry.NewGauge("db_pool_max_open_connections", "Maximum number of open connections to the database, 0 for unlimited.")
+	open := registry.NewGauge("db_pool_open_connections", "Established connections to the database, in use or idle.")
+	inUse := registry.NewGauge("db_pool_in_use_connections", "Connections currently in use.")
+	idle := registry.NewGauge("db_pool_idle_connections", "Idle connections.")
+	waits := registry.NewCounter("db_pool_wait_total", "Connections waited for because the pool was exhausted.")
+	waitSeconds := registry.NewCounter("db_pool_wait_seconds_total", "Time spent waiting for a connection.")
+	closed := registry.NewCounter("db_pool_closed_total", "Connections closed by the pool, by reason max_idle, max_idle_time or max_lifetime.", "reason")
+
+	// The stats are totals since the pool was opened, the counters are advanced by the difference to the last scrape.
+	var mu sync.Mutex
+	var last sql.DBStats
+
+	registry.OnCollect(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		stats := db.Stats()
+
+		maxOpen.Set(float64(stats.MaxOpenConnections))
+		open.Set(float64(stats.OpenConnections))
+		inUse.Set(float64(stats.InUse))
+		idle.Set(float64(stats.Idle))
+		waits.Add(float64(stats.WaitCount - last.WaitCount))
+		waitSeconds.Add((stats.WaitDuration - last.WaitDuration).Seconds())
+		closed.Add(float64(stats.MaxIdleClosed-last.MaxIdleClosed), "max_idle")
+		closed.Add(float64(stats.MaxIdleTimeClosed-last.MaxIdleTimeClosed), "max_idle_time")
+		closed.Add(float64(stats.MaxLifetimeClosed-last.MaxLifetimeClosed), "max_lifetime")
+
+		last = stats
+	})
+}
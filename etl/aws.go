@@ -0,0 +1,71 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"time"
+)
+
+// loadAWSConfig builds the SDK config with the credential source of the settings and logs which one is used, the
+// credentials are fetched once so that missing or invalid credentials fail the startup.
+func loadAWSConfig(ctx context.Context, logger *log.CustomLogger, settings config.AWS) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if settings.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(settings.Region))
+	}
+
+	// Describes the credentials for the startup log, without any secret.
+	source := settings.CredentialSource
+
+	switch settings.CredentialSource {
+	case config.CredentialsStatic:
+		opts = append(opts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(settings.AccessKeyID, settings.SecretAccessKey, settings.SessionToken)))
+	case config.CredentialsProfile:
+		opts = append(opts, awsconfig.WithSharedConfigProfile(settings.Profile))
+		source = fmt.Sprintf("%v %q", source, settings.Profile)
+	}
+
+	sdkConfig, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, errors.New(fmt.Sprintf("Error loading AWS config: %v", err.Error()))
+	}
+
+	// The role is assumed with the credentials of the default chain.
+	if settings.CredentialSource == config.CredentialsAssumeRole {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(sdkConfig), settings.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = settings.RoleSessionName
+			if settings.ExternalID != "" {
+				o.ExternalID = aws.String(settings.ExternalID)
+			}
+		})
+
+		sdkConfig.Credentials = aws.NewCredentialsCache(provider)
+		source = fmt.Sprintf("%v %v, session %q, external ID set: %v", source, settings.RoleARN, settings.RoleSessionName, settings.ExternalID != "")
+	}
+
+	retrieveCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	creds, err := sdkConfig.Credentials.Retrieve(retrieveCtx)
+	if err != nil {
+		return aws.Config{}, errors.New(fmt.Sprintf("Error retrieving AWS credentials from %v: %v", source, err.Error()))
+	}
+
+	endpoint := settings.Endpoint
+	if endpoint == "" {
+		endpoint = "default"
+	}
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Using AWS credentials from %v (provider %v), region %v, endpoint %v", source, creds.Source, sdkConfig.Region, endpoint)}
+	logger.Log(&lm)
+
+	return sdkConfig, nil
+}
@@ -0,0 +1,149 @@
+package etl
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"net/http"
+	"strings"
+)
+
+// ErrNoAdminToken is returned when the control plane is registered without a token.
+var ErrNoAdminToken = errors.New("no admin token is configured")
+
+// Control serves the control plane of the worker: pause, resume, drain, status and the polling config.
+type Control struct {
+	logger    *log.CustomLogger
+	processor IProcessor
+	token     string
+}
+
+// pollingConfig is the body of GET and PUT /config.
+type pollingConfig struct {
+	MaxMessages *int32 `json:"max_messages"`
+	MaxWaitTime *int32 `json:"max_wait_time"`
+}
+
+// NewControl returns the control plane of the processor, every request needs the token as a bearer token.
+func NewControl(logger *log.CustomLogger, processor IProcessor, token string) *Control {
+	return &Control{
+		logger:    logger,
+		processor: processor,
+		token:     token,
+	}
+}
+
+// Register adds the control endpoints to the mux. Without a token the control plane would be open to anyone reaching
+// the port, so no endpoint is added and ErrNoAdminToken is returned.
+func (c *Control) Register(mux *http.ServeMux) error {
+	if c.token == "" {
+		return ErrNoAdminToken
+	}
+
+	mux.HandleFunc("/pause", c.guard(http.MethodPost, c.transition("pause", c.processor.Pause)))
+	mux.HandleFunc("/resume", c.guard(http.MethodPost, c.transition("resume", c.processor.Resume)))
+	mux.HandleFunc("/drain", c.guard(http.MethodPost, c.transition("drain", c.processor.Drain)))
+	mux.HandleFunc("/status", c.guard(http.MethodGet, c.Status))
+	mux.HandleFunc("/config", c.guard("", c.Config))
+
+	return nil
+}
+
+// Status returns the state, backoff and counters of the worker.
+func (c *Control) Status(w http.ResponseWriter, _ *http.Request) {
+	c.respond(w, http.StatusOK, c.processor.Status())
+}
+
+// Config returns the polling config on GET and changes it on PUT, fields left out of the body keep their value.
+func (c *Control) Config(w http.ResponseWriter, r *http.Request) {
+	status := c.processor.Status()
+	maxMessages, maxWaitTime := status.MaxMessages, status.MaxWaitTime
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body pollingConfig
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			c.fail(w, r, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err.Error()))
+			return
+		}
+
+		if body.MaxMessages != nil {
+			maxMessages = *body.MaxMessages
+		}
+
+		if body.MaxWaitTime != nil {
+			maxWaitTime = *body.MaxWaitTime
+		}
+
+		if err := c.processor.SetPolling(maxMessages, maxWaitTime); err != nil {
+			c.fail(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		c.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	c.respond(w, http.StatusOK, pollingConfig{MaxMessages: &maxMessages, MaxWaitTime: &maxWaitTime})
+}
+
+// transition changes the state of the worker, a change that is not allowed from the current state is a conflict.
+func (c *Control) transition(action string, change func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := c.processor.State()
+
+		err := change()
+		if errors.Is(err, ErrInvalidTransition) {
+			c.fail(w, r, http.StatusConflict, fmt.Sprintf("can not %v a %v worker", action, state))
+			return
+		}
+
+		lm := log.Message{Level: "INFO", Method: r.Method, URI: r.URL.Path, StatusCode: http.StatusAccepted, Msg: fmt.Sprintf("Worker %v requested from %v", action, r.RemoteAddr)}
+		c.logger.Log(&lm)
+
+		// Draining finishes in the background, the status tells when the worker stopped.
+		c.respond(w, http.StatusAccepted, c.processor.Status())
+	}
+}
+
+// guard checks the method, unless it is empty, and the bearer token of the request.
+func (c *Control) guard(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if c.token == "" || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(bearer)), []byte(c.token)) != 1 {
+			c.fail(w, r, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		if method != "" && r.Method != method {
+			w.Header().Set("Allow", method)
+			c.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// fail logs the rejected request and writes the error response.
+func (c *Control) fail(w http.ResponseWriter, r *http.Request, statusCode int, reason string) {
+	lm := log.Message{Level: "WARN", Method: r.Method, URI: r.URL.Path, StatusCode: statusCode, ErrorMessage: reason}
+	c.logger.Log(&lm)
+
+	c.respond(w, statusCode, struct {
+		StatusCode int    `json:"code"`
+		Err        string `json:"message"`
+	}{StatusCode: statusCode, Err: reason})
+}
+
+func (c *Control) respond(w http.ResponseWriter, statusCode int, body interface{}) {
+	respJson, _ := json.Marshal(body)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(respJson)
+}
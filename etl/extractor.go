@@ -1,106 +1,124 @@
 package etl
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/keystore"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
-	"io"
-	"net/http"
+	"sync/atomic"
 )
 
 type extract struct {
-	httpClient    *http.Client
+	sqsClient     ISQSWrapper
 	logger        *log.CustomLogger
-	sqsEndpoint   string
-	noOfMessages  int32
-	waitTimeInSec int32
+	noOfMessages  atomic.Int32
+	waitTimeInSec atomic.Int32
 	encryptionKey string
+	keyStore      keystore.KeyStore
+	policy        model.MaskingPolicy
+	tokenizer     model.Tokenizer
+	metrics       *Metrics
 }
 
-// NewExtracter creates a new instance of the Extractor and initializes it with the SQS endpoint from environment variables.
-func NewExtracter(logger *log.CustomLogger, encryptionKey string, sqsEndpoint string, noOfMessages, waitTimeInSec int32) IExtractor {
-	return &extract{
-		httpClient:    new(http.Client),
+// NewExtracter creates a new instance of the Extractor receiving messages from the queue of the SQS client.
+// When keyStore is not nil every user's PII is encrypted with that user's own data key instead of the encryption key.
+// The tokenizer is only used by fields the policy tokenizes and may be nil otherwise.
+func NewExtracter(logger *log.CustomLogger, metrics *Metrics, encryptionKey string, keyStore keystore.KeyStore, policy model.MaskingPolicy, tokenizer model.Tokenizer, sqsClient ISQSWrapper, noOfMessages, waitTimeInSec int32) IExtractor {
+	ex := &extract{
+		sqsClient:     sqsClient,
 		logger:        logger,
-		sqsEndpoint:   sqsEndpoint,
-		noOfMessages:  noOfMessages,
-		waitTimeInSec: waitTimeInSec,
 		encryptionKey: encryptionKey,
+		keyStore:      keyStore,
+		policy:        policy,
+		tokenizer:     tokenizer,
+		metrics:       metrics,
 	}
-}
-
-// FetchDataFromSQS fetches data from the SQS endpoint, processes the response, and returns a model.Response.
-func (ex extract) FetchDataFromSQS() ([]model.Response, error) {
-	endpoint := ex.sqsEndpoint + fmt.Sprintf("&MaxNumberOfMessages=%v&WaitTimeSeconds=%v", ex.noOfMessages, ex.waitTimeInSec)
 
-	// Create a new GET request to the SQS endpoint.
-	req, err := http.NewRequest("GET", endpoint, nil)
-	if err != nil {
-		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error creating request to sqs enpoint: %v", err.Error())}
-		ex.logger.Log(&lm)
+	ex.SetPolling(noOfMessages, waitTimeInSec)
 
-		return nil, err
-	}
-
-	// Send the request and receive the response.
-	resp, err := ex.httpClient.Do(req)
-	if err != nil {
-		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error sending request to sqs enpoint: %v", err.Error())}
-		ex.logger.Log(&lm)
-
-		return nil, err
-	}
+	return ex
+}
 
-	defer resp.Body.Close()
+// Polling returns the number of messages asked for per poll and how long a poll waits for messages.
+func (ex *extract) Polling() (noOfMessages, waitTimeInSec int32) {
+	return ex.noOfMessages.Load(), ex.waitTimeInSec.Load()
+}
 
-	// Read the response body.
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error reading response from sqs enpoint : %v", err.Error())}
-		ex.logger.Log(&lm)
+// SetPolling changes the polling settings, the next poll uses them.
+func (ex *extract) SetPolling(noOfMessages, waitTimeInSec int32) {
+	ex.noOfMessages.Store(noOfMessages)
+	ex.waitTimeInSec.Store(waitTimeInSec)
+}
 
-		return nil, err
-	}
+// FetchDataFromSQS receives messages from the queue, masks them and returns them as model.Response.
+func (ex *extract) FetchDataFromSQS() ([]model.Response, error) {
+	ctx := context.Background()
+	noOfMessages, waitTimeInSec := ex.Polling()
 
-	// Unmarshal the XML response into the ReceiveMessageResponse struct.
-	var sqsMessageResponse model.ReceiveMessageResponse
-	err = xml.Unmarshal(body, &sqsMessageResponse)
+	messages, err := ex.sqsClient.GetMessages(ctx, noOfMessages, waitTimeInSec)
 	if err != nil {
-		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error unmarshalling XML response from sqs enpoint : %v", err.Error())}
-		ex.logger.Log(&lm)
-
 		return nil, err
 	}
 
 	// Initialize a new Response struct.
 	var msglist []model.Response
-	if sqsMessageResponse.ReceiveMessageResult.Message != nil && len(sqsMessageResponse.ReceiveMessageResult.Message) > 0 {
-		for _, msg := range sqsMessageResponse.ReceiveMessageResult.Message {
+	if len(messages) > 0 {
+		ex.metrics.MessagesReceived.Add(float64(len(messages)))
+
+		// Data keys fetched for this batch, keyed by user id.
+		userKeys := make(map[string]string)
+
+		for _, sqsMsg := range messages {
+			msg := toMessage(sqsMsg)
+
 			var res model.Response
 
 			// Unmarshal the JSON body of the SQS message into the Response struct.
+			// A message that is not JSON never will be, so it is dropped instead of failing the batch.
 			err = json.Unmarshal([]byte(msg.Body), &res)
 			if err != nil {
-				lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error unmarshalling JSON body from XML response from sqs enpoint : %v", err.Error())}
-				ex.logger.Log(&lm)
-
-				fmt.Println("Error unmarshalling JSON body:", err)
-				return nil, err
+				ex.reject(&msg, "invalid_json", err.Error())
+				continue
 			}
 
 			if msg.MessageId != nil && res.UserID != nil {
 				// Set additional data from the SQS message response into the Response struct.
-				res.SetData(sqsMessageResponse.ResponseMetadata.RequestId, &msg)
+				res.SetData(&msg)
+
+				// Pick the key used to mask this user's data.
+				key := ex.encryptionKey
+				if ex.keyStore != nil {
+					userKey, ok := userKeys[*res.UserID]
+					if !ok {
+						userKey, err = ex.keyStore.DataKey(ctx, *res.UserID)
+						if err != nil {
+							lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error fetching data key for user : %v", err.Error())}
+							ex.logger.Log(&lm)
+
+							return nil, err
+						}
+
+						userKeys[*res.UserID] = userKey
+					}
+
+					key = userKey
+
+					res.PerUserKey = true
+				}
 
-				// Mask sensitive data in the Response struct.
-				err = res.MaskBody(ex.encryptionKey)
+				// Mask sensitive data in the Response struct as defined by the masking policy.
+				err = res.MaskBody(ctx, ex.policy, model.MaskingKeys{EncryptionKey: key, PseudonymKey: ex.encryptionKey, Tokenizer: ex.tokenizer})
 				if err != nil {
 					return nil, err
 				}
 
 				msglist = append(msglist, res)
+			} else {
+				ex.reject(&msg, "missing_fields", "no user_id")
 			}
 		}
 	}
@@ -108,3 +126,32 @@ func (ex extract) FetchDataFromSQS() ([]model.Response, error) {
 	// Return the populated Response struct.
 	return msglist, nil
 }
+
+// reject logs and counts a message that can not be loaded and deletes it from the queue, so it is not received again.
+// The body is not logged since it may carry PII.
+func (ex *extract) reject(msg *model.Message, reason, detail string) {
+	lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Dropping sqs message %v, %v: %v", aws.ToString(msg.MessageId), reason, detail)}
+	ex.logger.Log(&lm)
+	ex.metrics.MessagesRejected.Inc(reason)
+
+	if err := ex.sqsClient.DeleteMessages([]*model.Response{{MessageId: msg.MessageId, ReceiptHandle: msg.ReceiptHandle}}); err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error deleting dropped sqs message %v: %v", aws.ToString(msg.MessageId), err.Error())}
+		ex.logger.Log(&lm)
+	}
+}
+
+// Ping checks that the queue answers, by asking for its attributes instead of receiving messages.
+func (ex *extract) Ping(ctx context.Context) error {
+	return ex.sqsClient.Ping(ctx)
+}
+
+// toMessage returns the fields of the SQS message the extractor uses.
+func toMessage(msg types.Message) model.Message {
+	return model.Message{
+		MessageId:     msg.MessageId,
+		ReceiptHandle: aws.ToString(msg.ReceiptHandle),
+		MD5OfBody:     aws.ToString(msg.MD5OfBody),
+		Body:          aws.ToString(msg.Body),
+		Attributes:    msg.Attributes,
+	}
+}
@@ -0,0 +1,121 @@
+package etl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is a named loader the fan-out loader writes every batch to.
+type Sink struct {
+	Name   string
+	Loader ILoader
+}
+
+type fanOut struct {
+	logger     *log.CustomLogger
+	metrics    *Metrics
+	sqsClient  ISQSWrapper
+	sinks      []Sink
+	requireAll bool
+}
+
+// NewFanOutLoader returns an ILoader writing every batch to the primary and the secondary sinks concurrently. The
+// messages of a batch are deleted from the queue once the primary sink, or with requireAll every sink, loaded it.
+// Otherwise SQS delivers them again and every sink loads them again, also the ones that succeeded, except that the
+// database sink skips the messages it inserted before.
+func NewFanOutLoader(logger *log.CustomLogger, metrics *Metrics, sc ISQSWrapper, requireAll bool, primary Sink, secondary ...Sink) ILoader {
+	return &fanOut{
+		logger:     logger,
+		metrics:    metrics,
+		sqsClient:  sc,
+		sinks:      append([]Sink{primary}, secondary...),
+		requireAll: requireAll,
+	}
+}
+
+func (f *fanOut) BatchInsert(responses []model.Response) error {
+	return f.load(responses, ILoader.BatchInsert)
+}
+
+func (f *fanOut) SequentialInsert(responses []model.Response) error {
+	return f.load(responses, ILoader.SequentialInsert)
+}
+
+// Close closes every sink and returns the first error.
+func (f *fanOut) Close() error {
+	var first error
+
+	for _, sink := range f.sinks {
+		if err := sink.Loader.Close(); err != nil {
+			lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error closing sink %v: %v", sink.Name, err.Error())}
+			f.logger.Log(&lm)
+
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
+}
+
+// load writes the batch to every sink, reports each sink's result and acknowledges the batch when enough succeeded.
+func (f *fanOut) load(responses []model.Response, write func(ILoader, []model.Response) error) error {
+	errs := make([]error, len(f.sinks))
+
+	var wg sync.WaitGroup
+	for i, sink := range f.sinks {
+		wg.Add(1)
+
+		go func(i int, sink Sink) {
+			defer wg.Done()
+
+			start := time.Now()
+			errs[i] = write(sink.Loader, responses)
+			f.metrics.SinkDuration.Observe(time.Since(start).Seconds(), sink.Name)
+
+			if errs[i] != nil {
+				f.metrics.SinkBatches.Inc(sink.Name, "error")
+
+				lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Sink %v failed to load a batch of %d records: %v", sink.Name, len(responses), errs[i].Error())}
+				f.logger.Log(&lm)
+
+				return
+			}
+
+			f.metrics.SinkBatches.Inc(sink.Name, "ok")
+		}(i, sink)
+	}
+
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil && (i == 0 || f.requireAll) {
+			failed = append(failed, fmt.Sprintf("%v: %v", f.sinks[i].Name, err.Error()))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("batch not acknowledged, sinks failed: %v", strings.Join(failed, "; ")))
+	}
+
+	messages := make([]*model.Response, len(responses))
+	for i := range responses {
+		messages[i] = &responses[i]
+	}
+
+	if err := f.sqsClient.DeleteMessages(messages); err != nil {
+		f.metrics.AckErrors.Inc()
+		return errors.New(fmt.Sprintf("Error acknowledging the loaded batch, its messages will be delivered again: %v", err.Error()))
+	}
+
+	f.metrics.MessagesAcked.Add(float64(len(messages)))
+
+	return nil
+}
@@ -0,0 +1,441 @@
+package etl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sinkExtensions are the file extensions of the file sink formats.
+var sinkExtensions = map[string]string{
+	config.FormatNDJSON:  ".ndjson.gz",
+	config.FormatParquet: ".parquet",
+}
+
+// sinkRow is a loaded record as the file sink writes it, the masked fields as they are stored in Postgres. The
+// message ID identifies records written again after SQS delivered their message twice.
+type sinkRow struct {
+	MessageID      *string   `json:"message_id" parquet:"message_id"`
+	UserID         *string   `json:"user_id" parquet:"user_id"`
+	DeviceType     *string   `json:"device_type" parquet:"device_type"`
+	MaskedIP       *string   `json:"masked_ip" parquet:"masked_ip"`
+	MaskedDeviceID *string   `json:"masked_device_id" parquet:"masked_device_id"`
+	Locale         string    `json:"locale" parquet:"locale"`
+	AppVersion     string    `json:"app_version" parquet:"app_version"`
+	IPPseudonym    *string   `json:"ip_pseudonym" parquet:"ip_pseudonym"`
+	PerUserKey     bool      `json:"per_user_key" parquet:"per_user_key"`
+	SentAt         time.Time `json:"sent_at" parquet:"sent_at,timestamp(millisecond)"`
+	LoadedAt       time.Time `json:"loaded_at" parquet:"loaded_at,timestamp(millisecond)"`
+}
+
+// sinkEncoder encodes rows to a file of the file sink. Flush writes the buffered rows to the file, Close finishes the
+// file without closing it.
+type sinkEncoder interface {
+	Write(rows []sinkRow) error
+	Flush() error
+	Close() error
+}
+
+// hourFile is the file of the records loaded in one hour. It is written as path + ".inprogress".
+type hourFile struct {
+	hour    time.Time
+	path    string
+	file    *os.File
+	encoder sinkEncoder
+	rows    int64
+	timer   *time.Timer
+}
+
+type fileSink struct {
+	logger  *log.CustomLogger
+	metrics *Metrics
+	dir     string
+	format  string
+
+	mu      sync.Mutex
+	current *hourFile
+
+	// partsHour is the hour of the parquet batch files not compacted yet, the zero time when there are none.
+	partsHour  time.Time
+	partsTimer *time.Timer
+}
+
+// NewFileSink returns an ILoader writing every batch to the file of the current hour in dir, e.g.
+// dir/date=2024-06-01/hour=14/logins-<unix nanoseconds>.ndjson.gz. A file ends with .inprogress until its hour is
+// over or the sink is closed, then it is completed and renamed. Parquet is only readable with its footer, so every
+// batch is written to a complete file of its own under dir/_parts before it is acknowledged, and the batch files of an
+// hour are compacted into the file of the hour when it is over or the sink is closed.
+// The files left in progress by an ETL that stopped without closing the sink are recovered first, so dir must not be
+// shared with another ETL.
+func NewFileSink(logger *log.CustomLogger, metrics *Metrics, dir, format string) (ILoader, error) {
+	if _, ok := sinkExtensions[format]; !ok {
+		return nil, errors.New(fmt.Sprintf("unknown file sink format %q", format))
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating the file sink directory: %v", err.Error()))
+	}
+
+	f := &fileSink{
+		logger:  logger,
+		metrics: metrics,
+		dir:     dir,
+		format:  format,
+	}
+
+	if err := f.recoverAll(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error recovering the file sink files in progress: %v", err.Error()))
+	}
+
+	return f, nil
+}
+
+// BatchInsert appends the batch to the file of the current hour and syncs it, or with parquet writes it to a batch file
+// of its own and completes it, so a batch is on disk once it is acknowledged. A file that fails to be written is closed
+// as .failed and the next batch starts a new one.
+func (f *fileSink) BatchInsert(responses []model.Response) error {
+	loadedAt := time.Now().UTC()
+
+	rows := make([]sinkRow, len(responses))
+	for i, response := range responses {
+		rows[i] = sinkRow{MessageID: response.MessageId, UserID: response.UserID, DeviceType: response.DeviceType, MaskedIP: response.IP, MaskedDeviceID: response.DeviceID,
+			Locale: response.Locale, AppVersion: response.AppVersion, IPPseudonym: response.IPPseudonym, PerUserKey: response.PerUserKey, SentAt: response.SentTimestamp, LoadedAt: loadedAt}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	hour := loadedAt.Truncate(time.Hour)
+	if f.format == config.FormatParquet {
+		return f.writePart(hour, rows)
+	}
+
+	if f.current != nil && !f.current.hour.Equal(hour) {
+		if err := f.complete(); err != nil {
+			return err
+		}
+	}
+
+	if f.current == nil {
+		current, err := f.open(hour)
+		if err != nil {
+			return err
+		}
+
+		f.current = current
+	}
+
+	err := f.current.encoder.Write(rows)
+	if err == nil {
+		err = f.current.encoder.Flush()
+	}
+
+	if err == nil {
+		err = f.current.file.Sync()
+	}
+
+	if err != nil {
+		f.fail()
+		return errors.New(fmt.Sprintf("Error writing to the file sink: %v", err.Error()))
+	}
+
+	f.current.rows += int64(len(rows))
+
+	return nil
+}
+
+func (f *fileSink) SequentialInsert(responses []model.Response) error {
+	return f.BatchInsert(responses)
+}
+
+// Close completes the file of the current hour.
+func (f *fileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.partsHour.IsZero() {
+		f.partsTimer.Stop()
+
+		hour := f.partsHour
+		f.partsHour = time.Time{}
+
+		return f.compact(hour)
+	}
+
+	if f.current == nil {
+		return nil
+	}
+
+	return f.complete()
+}
+
+// open creates the file of the hour and schedules completing it when the hour is over.
+func (f *fileSink) open(hour time.Time) (*hourFile, error) {
+	dir := hourDir(f.dir, hour)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating the file sink directory: %v", err.Error()))
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("logins-%d%s", time.Now().UnixNano(), sinkExtensions[f.format]))
+
+	file, err := os.Create(path + ".inprogress")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating the file sink file: %v", err.Error()))
+	}
+
+	current := &hourFile{hour: hour, path: path, file: file, encoder: newSinkEncoder(f.format, file)}
+	current.timer = time.AfterFunc(time.Until(hour.Add(time.Hour)), func() { f.completeHour(hour) })
+
+	return current, nil
+}
+
+// completeHour completes the file of the hour unless a batch already did.
+func (f *fileSink) completeHour(hour time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.current == nil || !f.current.hour.Equal(hour) {
+		return
+	}
+
+	if err := f.complete(); err != nil {
+		lm := log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+		f.logger.Log(&lm)
+	}
+}
+
+// complete finishes the current file and renames it to its final name.
+func (f *fileSink) complete() error {
+	current := f.current
+	f.current = nil
+
+	current.timer.Stop()
+
+	err := current.encoder.Close()
+	if err == nil {
+		err = current.file.Sync()
+	}
+
+	if closeErr := current.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(current.path+".inprogress", current.path)
+	}
+
+	if err != nil {
+		_ = os.Rename(current.path+".inprogress", current.path+".failed")
+		return errors.New(fmt.Sprintf("Error completing the file sink file %v: %v", current.path, err.Error()))
+	}
+
+	f.metrics.SinkFiles.Inc()
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("File sink completed %v with %d records", current.path, current.rows)}
+	f.logger.Log(&lm)
+
+	return nil
+}
+
+// fail closes the current file as .failed, its end may hold a partial batch.
+func (f *fileSink) fail() {
+	current := f.current
+	f.current = nil
+
+	current.timer.Stop()
+	_ = current.file.Close()
+	_ = os.Rename(current.path+".inprogress", current.path+".failed")
+}
+
+// recoverAll recovers every file left in progress in the sink directory and compacts the parquet batch files left.
+func (f *fileSink) recoverAll() error {
+	err := filepath.WalkDir(f.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && path == filepath.Join(f.dir, partsDir) {
+			return filepath.SkipDir
+		}
+
+		if d.IsDir() || !strings.HasSuffix(path, ".inprogress") {
+			return nil
+		}
+
+		f.recoverFile(strings.TrimSuffix(path, ".inprogress"))
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return f.recoverParts()
+}
+
+// recoverFile completes the records of an ndjson file left in progress, its synced batches may have been acknowledged.
+// Its last batch may be partial, the records cut off are dropped and a batch that was not acknowledged is written
+// again when SQS delivers it again. An ndjson file that can not be recovered is quarantined as .failed. A parquet file
+// left in progress was being compacted, its batch files are only deleted once it is complete, so it is deleted and
+// the batch files are compacted again.
+func (f *fileSink) recoverFile(path string) {
+	var rows int64
+	var err error
+
+	if strings.HasSuffix(path, sinkExtensions[config.FormatParquet]) {
+		if err = os.Remove(path + ".inprogress"); err == nil {
+			lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("File sink deleted %v.inprogress, its batch files are compacted again", path)}
+			f.logger.Log(&lm)
+
+			return
+		}
+	}
+
+	if strings.HasSuffix(path, sinkExtensions[config.FormatNDJSON]) {
+		rows, err = recoverNDJSON(path)
+		if err == nil && rows > 0 {
+			err = os.Remove(path + ".inprogress")
+		}
+
+		if err == nil && rows > 0 {
+			f.metrics.SinkFiles.Inc()
+
+			lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("File sink recovered %v with %d records", path, rows)}
+			f.logger.Log(&lm)
+
+			return
+		}
+
+		_ = os.Remove(path)
+	}
+
+	reason := "it was left in progress"
+	if err != nil {
+		reason = err.Error()
+	}
+
+	lm := log.Message{Level: "WARN", ErrorMessage: fmt.Sprintf("File sink quarantined %v.failed: %v", path, reason)}
+	if err = os.Rename(path+".inprogress", path+".failed"); err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error quarantining the file sink file %v: %v", path, err.Error())}
+	}
+
+	f.logger.Log(&lm)
+}
+
+// recoverNDJSON writes the complete records of path + ".inprogress" to path and returns their number. A file in
+// progress has no gzip footer and may end in a cut off record, reading stops there.
+func recoverNDJSON(path string) (int64, error) {
+	in, err := os.Open(path + ".inprogress")
+	if err != nil {
+		return 0, err
+	}
+
+	defer in.Close()
+
+	// A file that was created but never written to has no gzip header either.
+	gzIn, err := gzip.NewReader(in)
+	if err != nil {
+		return 0, nil
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer out.Close()
+
+	gzOut := gzip.NewWriter(out)
+
+	var rows int64
+	reader := bufio.NewReader(gzIn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil || !json.Valid(line) {
+			break
+		}
+
+		if _, err = gzOut.Write(line); err != nil {
+			return 0, err
+		}
+
+		rows++
+	}
+
+	if err = gzOut.Close(); err != nil {
+		return 0, err
+	}
+
+	return rows, out.Sync()
+}
+
+// hourDir returns the directory of the files of the hour under dir, e.g. dir/date=2024-06-01/hour=14.
+func hourDir(dir string, hour time.Time) string {
+	return filepath.Join(dir, "date="+hour.Format(time.DateOnly), fmt.Sprintf("hour=%02d", hour.Hour()))
+}
+
+func newSinkEncoder(format string, w io.Writer) sinkEncoder {
+	if format == config.FormatParquet {
+		return &parquetSink{w: parquet.NewGenericWriter[sinkRow](w, parquet.Compression(&parquet.Zstd))}
+	}
+
+	gz := gzip.NewWriter(w)
+
+	return &ndjsonSink{gz: gz, enc: json.NewEncoder(gz)}
+}
+
+type ndjsonSink struct {
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func (n *ndjsonSink) Write(rows []sinkRow) error {
+	for _, row := range rows {
+		if err := n.enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *ndjsonSink) Flush() error {
+	return n.gz.Flush()
+}
+
+// Close writes the gzip footer.
+func (n *ndjsonSink) Close() error {
+	return n.gz.Close()
+}
+
+type parquetSink struct {
+	w *parquet.GenericWriter[sinkRow]
+}
+
+func (p *parquetSink) Write(rows []sinkRow) error {
+	_, err := p.w.Write(rows)
+	return err
+}
+
+// Flush writes the buffered rows as a row group.
+func (p *parquetSink) Flush() error {
+	return p.w.Flush()
+}
+
+// Close writes the parquet footer.
+func (p *parquetSink) Close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,250 @@
+package etl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// partsDir is the directory of the parquet batch files under the sink directory, named so that data lake readers skip
+// it, e.g. dir/_parts/date=2024-06-01/hour=14/part-<unix nanoseconds>.parquet.
+const partsDir = "_parts"
+
+// writePart writes the rows to a complete batch file of the hour and schedules compacting the batch files of the hour
+// when it is over. The batch files of the previous hour are compacted first.
+func (f *fileSink) writePart(hour time.Time, rows []sinkRow) error {
+	if !f.partsHour.IsZero() && !f.partsHour.Equal(hour) {
+		f.compactHour(f.partsHour)
+	}
+
+	dir := hourDir(filepath.Join(f.dir, partsDir), hour)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.New(fmt.Sprintf("Error creating the file sink directory: %v", err.Error()))
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("part-%d%s", time.Now().UnixNano(), sinkExtensions[config.FormatParquet]))
+
+	file, err := os.Create(path + ".inprogress")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating the file sink file: %v", err.Error()))
+	}
+
+	encoder := newSinkEncoder(config.FormatParquet, file)
+
+	err = encoder.Write(rows)
+	if err == nil {
+		err = encoder.Close()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(path+".inprogress", path)
+	}
+
+	if err != nil {
+		_ = os.Rename(path+".inprogress", path+".failed")
+		return errors.New(fmt.Sprintf("Error writing to the file sink: %v", err.Error()))
+	}
+
+	if f.partsHour.IsZero() {
+		f.partsHour = hour
+		f.partsTimer = time.AfterFunc(time.Until(hour.Add(time.Hour)), func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			if f.partsHour.Equal(hour) {
+				f.compactHour(hour)
+			}
+		})
+	}
+
+	return nil
+}
+
+// compactHour compacts the batch files of the hour. A failure is logged, the batch files are kept and compacted again
+// when the sink is opened next.
+func (f *fileSink) compactHour(hour time.Time) {
+	f.partsTimer.Stop()
+	f.partsHour = time.Time{}
+
+	if err := f.compact(hour); err != nil {
+		lm := log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+		f.logger.Log(&lm)
+	}
+}
+
+// compact writes the rows of the batch files of the hour to the file of the hour and deletes the batch files. The file
+// is named after the first batch file, which is deleted last, so a compaction interrupted after the file was completed
+// only deletes the batch files left when it is run again.
+func (f *fileSink) compact(hour time.Time) error {
+	dir := hourDir(filepath.Join(f.dir, partsDir), hour)
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error listing the file sink batch files: %v", err.Error()))
+	}
+
+	var parts []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "part-") && strings.HasSuffix(entry.Name(), sinkExtensions[config.FormatParquet]) {
+			parts = append(parts, entry.Name())
+		}
+	}
+
+	sort.Strings(parts)
+
+	if len(parts) > 0 {
+		path := filepath.Join(hourDir(f.dir, hour), "logins-"+strings.TrimPrefix(parts[0], "part-"))
+
+		if _, err = os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			if err = f.writeCompacted(path, dir, parts); err != nil {
+				return err
+			}
+		}
+
+		for i := len(parts) - 1; i >= 0; i-- {
+			if err = os.Remove(filepath.Join(dir, parts[i])); err != nil {
+				return errors.New(fmt.Sprintf("Error deleting the file sink batch file: %v", err.Error()))
+			}
+		}
+	}
+
+	// Batch files quarantined as .failed keep the directory.
+	_ = os.Remove(dir)
+
+	return nil
+}
+
+// writeCompacted writes the rows of the batch files in dir to path, as path + ".inprogress" until it is complete.
+func (f *fileSink) writeCompacted(path, dir string, parts []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.New(fmt.Sprintf("Error creating the file sink directory: %v", err.Error()))
+	}
+
+	file, err := os.Create(path + ".inprogress")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating the file sink file: %v", err.Error()))
+	}
+
+	encoder := newSinkEncoder(config.FormatParquet, file)
+
+	var records int64
+	for _, part := range parts {
+		var rows []sinkRow
+
+		rows, err = parquet.ReadFile[sinkRow](filepath.Join(dir, part))
+		if err == nil {
+			err = encoder.Write(rows)
+		}
+
+		if err != nil {
+			break
+		}
+
+		records += int64(len(rows))
+	}
+
+	if err == nil {
+		err = encoder.Close()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(path+".inprogress", path)
+	}
+
+	if err != nil {
+		_ = os.Remove(path + ".inprogress")
+		return errors.New(fmt.Sprintf("Error compacting the file sink batch files into %v: %v", path, err.Error()))
+	}
+
+	f.metrics.SinkFiles.Inc()
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("File sink completed %v with %d records of %d batches", path, records, len(parts))}
+	f.logger.Log(&lm)
+
+	return nil
+}
+
+// recoverParts quarantines the batch files left in progress, their batches were not acknowledged, and compacts the
+// batch files of every hour left, including the current one.
+func (f *fileSink) recoverParts() error {
+	root := filepath.Join(f.dir, partsDir)
+	hours := make(map[time.Time]bool)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return filepath.SkipDir
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		hour, err := time.Parse("date=2006-01-02"+string(filepath.Separator)+"hour=15", rel)
+		if err != nil {
+			return nil
+		}
+
+		hours[hour] = true
+
+		if strings.HasSuffix(path, ".inprogress") {
+			failed := strings.TrimSuffix(path, ".inprogress") + ".failed"
+
+			lm := log.Message{Level: "WARN", ErrorMessage: fmt.Sprintf("File sink quarantined %v: its batch was not acknowledged", failed)}
+			if err = os.Rename(path, failed); err != nil {
+				lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error quarantining the file sink file %v: %v", path, err.Error())}
+			}
+
+			f.logger.Log(&lm)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for hour := range hours {
+		if err = f.compact(hour); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,24 +1,36 @@
 package etl
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
 )
 
 type IProcessor interface {
 	Worker()
+	Pause() error
+	Resume() error
+	Drain() error
+	State() State
+	Status() Status
+	SetPolling(maxMessages, maxWaitTime int32) error
 }
 
 type IExtractor interface {
 	FetchDataFromSQS() ([]model.Response, error)
+	Ping(ctx context.Context) error
+	Polling() (noOfMessages, waitTimeInSec int32)
+	SetPolling(noOfMessages, waitTimeInSec int32)
 }
 
 type ILoader interface {
 	BatchInsert(responses []model.Response) error
 	SequentialInsert(response []model.Response) error
+	Close() error
 }
 
 type ISQSWrapper interface {
-	GetMessages(maxMessages int32, waitTime int32) ([]types.Message, error)
+	GetMessages(ctx context.Context, maxMessages int32, waitTime int32) ([]types.Message, error)
+	Ping(ctx context.Context) error
 	DeleteMessages(messages []*model.Response) error
 }
@@ -6,54 +6,136 @@ import (
 	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
 	"strings"
+	"time"
 )
 
+// insertColumns is the number of bound values per row in BatchInsert, create_date is set by the database.
+const insertColumns = 9
+
+// loadedRetention is how long the IDs of inserted messages are kept, the longest SQS keeps a message.
+const loadedRetention = 14 * 24 * time.Hour
+
+// purgeInterval is how often the IDs of messages older than loadedRetention are deleted.
+const purgeInterval = time.Hour
+
+// insertStatement inserts the logins of the batch whose message IDs were not inserted before, in one statement so
+// that a login and its message ID are inserted together. The values are bound as text and cast where needed.
+const insertStatement = `WITH batch (message_id, user_id, device_type, masked_ip, masked_device_id, locale, app_version, per_user_key, ip_pseudonym) AS (VALUES %s),
+new_messages AS (INSERT INTO loaded_messages (message_id) SELECT message_id FROM batch ON CONFLICT DO NOTHING RETURNING message_id)
+INSERT INTO user_logins (user_id, device_type, masked_ip, masked_device_id, locale, app_version, per_user_key, ip_pseudonym, create_date)
+SELECT user_id, device_type, masked_ip, masked_device_id, locale, app_version, per_user_key::boolean, ip_pseudonym::inet, NOW() AT TIME ZONE 'UTC' FROM batch JOIN new_messages USING (message_id)`
+
 type load struct {
 	logger    *log.CustomLogger
+	metrics   *Metrics
 	dbConn    *sql.DB
-	sqsClient ISQSWrapper
+	lastPurge time.Time
 }
 
-// NewLoader creates a new instance of the ILoader with the provided database connection.
-func NewLoader(logger *log.CustomLogger, dbConn *sql.DB, sc ISQSWrapper) ILoader {
+// NewLoader creates a new instance of the ILoader with the provided database connection. The messages of the loaded
+// batches are deleted from the queue by the fan-out loader, see NewFanOutLoader. Messages delivered again after they
+// were inserted are skipped, their IDs are kept in loaded_messages.
+func NewLoader(logger *log.CustomLogger, metrics *Metrics, dbConn *sql.DB) ILoader {
 	return &load{
-		logger:    logger,
-		dbConn:    dbConn,
-		sqsClient: sc,
+		logger:  logger,
+		metrics: metrics,
+		dbConn:  dbConn,
 	}
 }
 
-// BatchInsert inserts a batch of responses into the PostgreSQL database.
+// BatchInsert inserts a batch of responses into the PostgreSQL database, skipping the messages already inserted.
 func (l *load) BatchInsert(responses []model.Response) error {
 	// Initialize slices to build the SQL statement
-	valueStrings := make([]string, 0, len(responses))     // Slice to hold value placeholders
-	valueArgs := make([]interface{}, 0, len(responses)*6) // Slice to hold the actual values
+	valueStrings := make([]string, 0, len(responses))                 // Slice to hold value placeholders
+	valueArgs := make([]interface{}, 0, len(responses)*insertColumns) // Slice to hold the actual values
+
+	// SQS may deliver a message twice in the same batch, it is inserted once.
+	seen := make(map[string]bool, len(responses))
 
 	// Iterate over the responses and construct the values part of the SQL statement
-	for i, response := range responses {
-		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, NOW() AT TIME ZONE 'UTC')", i*6+1, i*6+2, i*6+3, i*6+4, i*6+5, i*6+6))
-		valueArgs = append(valueArgs, response.UserID, response.DeviceType, response.IP, response.DeviceID, response.Locale, response.AppVersion)
+	for _, response := range responses {
+		if seen[*response.MessageId] {
+			continue
+		}
+
+		seen[*response.MessageId] = true
+
+		placeholders := make([]string, 0, insertColumns)
+		for col := 1; col <= insertColumns; col++ {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(valueArgs)+col))
+		}
+
+		valueStrings = append(valueStrings, fmt.Sprintf("(%s)", strings.Join(placeholders, ", ")))
+		valueArgs = append(valueArgs, response.MessageId, response.UserID, response.DeviceType, response.IP, response.DeviceID, response.Locale, response.AppVersion, response.PerUserKey, response.IPPseudonym)
 	}
 
 	// Join the value strings to form the complete SQL statement
-	stmt := fmt.Sprintf("INSERT INTO user_logins (user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date) VALUES %s",
-		strings.Join(valueStrings, ","))
+	stmt := fmt.Sprintf(insertStatement, strings.Join(valueStrings, ","))
 
 	// Execute the SQL statement with the value arguments
-	_, err := l.dbConn.Exec(stmt, valueArgs...)
+	start := time.Now()
+	res, err := l.dbConn.Exec(stmt, valueArgs...)
 	if err != nil {
+		l.metrics.InsertDuration.Observe(time.Since(start).Seconds(), "error")
+
 		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Failed to execute batch insert with error : %v", err.Error())}
 		l.logger.Log(&lm)
 
 		return err
 	}
 
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	loadedAt := time.Now()
+	l.metrics.InsertDuration.Observe(loadedAt.Sub(start).Seconds(), "ok")
+	l.metrics.BatchSize.Observe(float64(len(responses)))
+	l.metrics.MessagesLoaded.Add(float64(inserted))
+	l.metrics.MessagesDuplicate.Add(float64(int64(len(responses)) - inserted))
+
+	for _, response := range responses {
+		if !response.SentTimestamp.IsZero() {
+			l.metrics.Lag.Observe(loadedAt.Sub(response.SentTimestamp).Seconds())
+		}
+	}
+
 	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Successfully inserted a batch to database.")}
+	if skipped := int64(len(responses)) - inserted; skipped > 0 {
+		lm.Msg = fmt.Sprintf("Successfully inserted a batch to database, skipped %d messages inserted before.", skipped)
+	}
+
 	l.logger.Log(&lm)
 
+	l.purge(loadedAt)
+
 	return nil
 }
 
+// purge deletes the IDs of messages SQS no longer delivers, at most once every purgeInterval. A failure is logged and
+// retried with a later batch.
+func (l *load) purge(now time.Time) {
+	if now.Sub(l.lastPurge) < purgeInterval {
+		return
+	}
+
+	_, err := l.dbConn.Exec("DELETE FROM loaded_messages WHERE loaded_at < $1", now.UTC().Add(-loadedRetention))
+	if err != nil {
+		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Failed to purge loaded message IDs with error : %v", err.Error())}
+		l.logger.Log(&lm)
+
+		return
+	}
+
+	l.lastPurge = now
+}
+
 func (l *load) SequentialInsert(responses []model.Response) error {
 	return nil
 }
+
+// Close does nothing, the database connection is closed by its owner.
+func (l *load) Close() error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package etl
+
+import "github.com/shivasaicharanruthala/dataops-takehome-2/internal/metrics"
+
+// Metrics are the collectors of the ETL pipeline.
+type Metrics struct {
+	MessagesReceived  *metrics.Counter
+	MessagesRejected  *metrics.Counter
+	MessagesLoaded    *metrics.Counter
+	MessagesDuplicate *metrics.Counter
+	BatchSize         *metrics.Histogram
+	InsertDuration    *metrics.Histogram
+	EmptyPolls        *metrics.Counter
+	Backoff           *metrics.Gauge
+	Lag               *metrics.Histogram
+	SinkBatches       *metrics.Counter
+	SinkDuration      *metrics.Histogram
+	SinkFiles         *metrics.Counter
+	MessagesAcked     *metrics.Counter
+	AckErrors         *metrics.Counter
+}
+
+// NewMetrics registers the collectors of the ETL pipeline.
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		MessagesReceived:  registry.NewCounter("etl_messages_received_total", "Messages received from SQS."),
+		MessagesRejected:  registry.NewCounter("etl_messages_rejected_total", "Messages dropped before loading, by reason.", "reason"),
+		MessagesLoaded:    registry.NewCounter("etl_messages_loaded_total", "Messages inserted into the database."),
+		MessagesDuplicate: registry.NewCounter("etl_messages_duplicate_total", "Messages delivered again after they were inserted, skipped by the database sink."),
+		BatchSize:         registry.NewHistogram("etl_batch_size", "Records per batch insert.", []float64{1, 2, 5, 10, 25, 50, 100, 250}),
+		InsertDuration:    registry.NewHistogram("etl_insert_duration_seconds", "Latency of batch inserts, by result.", metrics.DefBuckets, "result"),
+		EmptyPolls:        registry.NewCounter("etl_empty_polls_total", "Polls of SQS that returned no messages."),
+		Backoff:           registry.NewGauge("etl_backoff_seconds", "Current wait between polls after consecutive empty polls."),
+		Lag:               registry.NewHistogram("etl_end_to_end_lag_seconds", "Time from a message being sent to SQS until it is inserted.", []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900, 3600}),
+		SinkBatches:       registry.NewCounter("etl_sink_batches_total", "Batches written to each sink, by sink and result.", "sink", "result"),
+		SinkDuration:      registry.NewHistogram("etl_sink_duration_seconds", "Latency of writing a batch to each sink.", metrics.DefBuckets, "sink"),
+		SinkFiles:         registry.NewCounter("etl_sink_files_total", "Files completed or recovered by the file sink."),
+		MessagesAcked:     registry.NewCounter("etl_messages_acknowledged_total", "Loaded messages deleted from SQS."),
+		AckErrors:         registry.NewCounter("etl_ack_errors_total", "Loaded batches whose messages could not be deleted from SQS."),
+	}
+}
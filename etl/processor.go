@@ -1,43 +1,102 @@
 package etl
 
 import (
+	"errors"
 	"fmt"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
-	"os"
-	"strconv"
+	"sync"
 	"time"
 )
 
+// State is the state of the worker.
+type State string
+
+const (
+	// StateRunning polls the queue and loads batches.
+	StateRunning State = "running"
+	// StatePaused stops polling until the worker is resumed or drained.
+	StatePaused State = "paused"
+	// StateDraining finishes the batch in flight, then the worker stops.
+	StateDraining State = "draining"
+	// StateStopped means Worker has returned.
+	StateStopped State = "stopped"
+)
+
+// ErrInvalidTransition is returned when the worker can not move to the requested state from its current state.
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// Status is a snapshot of the worker for operators.
+type Status struct {
+	State                 State      `json:"state"`
+	MaxMessages           int32      `json:"max_messages"`
+	MaxWaitTime           int32      `json:"max_wait_time"`
+	BackoffSeconds        float64    `json:"backoff_seconds"`
+	BatchesLoaded         int64      `json:"batches_loaded"`
+	BatchesFailed         int64      `json:"batches_failed"`
+	MessagesLoaded        int64      `json:"messages_loaded"`
+	FetchErrors           int64      `json:"fetch_errors"`
+	EmptyPolls            int64      `json:"empty_polls"`
+	ConsecutiveEmptyPolls int        `json:"consecutive_empty_polls"`
+	LastBatchAt           *time.Time `json:"last_batch_at"`
+}
+
 type transformer struct {
 	logger    *log.CustomLogger
+	metrics   *Metrics
 	extractor IExtractor
 	loader    ILoader
+
+	// The worker backs off after maxEmptyResponses empty polls in a row and stops after maxConsecutiveEmptyResponses.
+	maxEmptyResponses            int
+	maxConsecutiveEmptyResponses int
+
+	// mu guards status, wake is signalled whenever the state changes.
+	mu     sync.Mutex
+	status Status
+	wake   chan struct{}
 }
 
 // NewProcessor creates a new instance of the Processor with the given extractor and loader.
-func NewProcessor(logger *log.CustomLogger, extractor IExtractor, loader ILoader) IProcessor {
+func NewProcessor(logger *log.CustomLogger, metrics *Metrics, extractor IExtractor, loader ILoader, maxEmptyResponses, maxConsecutiveEmptyResponses int) IProcessor {
 	return &transformer{
-		logger:    logger,
-		extractor: extractor,
-		loader:    loader,
+		logger:                       logger,
+		metrics:                      metrics,
+		extractor:                    extractor,
+		loader:                       loader,
+		maxEmptyResponses:            maxEmptyResponses,
+		maxConsecutiveEmptyResponses: maxConsecutiveEmptyResponses,
+		status:                       Status{State: StateRunning},
+		wake:                         make(chan struct{}, 1),
 	}
 }
 
 // Worker is a function that continuously calls the API to fetch data and sends the result to a channel.
+// It returns once the worker is drained or the queue stayed empty for too long.
 func (p *transformer) Worker() {
-	// Retrieve maximum allowed empty responses and maximum consecutive empty responses from environment variables
-	maxEmptyResponses, _ := strconv.Atoi(os.Getenv("MAX_NO_RESPONSES"))
-	maxConsecutiveEmptyResponses, _ := strconv.Atoi(os.Getenv("MAX_CONSECUTIVE_NO_RESPONSES"))
+	defer p.transition(StateStopped)
+
 	initialWaitTime := time.Second
 	emptyResponseCount := 0
 	waitTime := initialWaitTime
 
 	for {
+		switch p.State() {
+		case StatePaused:
+			<-p.wake
+			continue
+		case StateDraining:
+			lm := log.Message{Level: "INFO", Msg: "Drained, stopping the worker"}
+			p.logger.Log(&lm)
+
+			return
+		}
+
 		response, err := p.extractor.FetchDataFromSQS()
 		if err != nil {
 			lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error fetching data: %v", err.Error())}
 			p.logger.Log(&lm)
 
+			p.update(func(s *Status) { s.FetchErrors++ })
 			continue
 		}
 
@@ -47,24 +106,40 @@ func (p *transformer) Worker() {
 				lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error inserting batch: %v", err.Error())}
 				p.logger.Log(&lm)
 
+				p.update(func(s *Status) { s.BatchesFailed++ })
+			} else {
+				now := time.Now().UTC()
+				p.update(func(s *Status) {
+					s.BatchesLoaded++
+					s.MessagesLoaded += int64(len(response))
+					s.LastBatchAt = &now
+				})
 			}
 
 			emptyResponseCount = 0     // Reset the empty response counter
 			waitTime = initialWaitTime // Reset the wait time
+			p.metrics.Backoff.Set(0)
+			p.update(func(s *Status) { s.ConsecutiveEmptyPolls, s.BackoffSeconds = 0, 0 })
 		} else {
 			lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Received empty response")}
 			p.logger.Log(&lm)
 
 			emptyResponseCount++
-			if emptyResponseCount >= maxEmptyResponses {
+			p.metrics.EmptyPolls.Inc()
+			p.update(func(s *Status) { s.EmptyPolls, s.ConsecutiveEmptyPolls = s.EmptyPolls+1, emptyResponseCount })
+
+			if emptyResponseCount >= p.maxEmptyResponses {
 				lm = log.Message{Level: "INFO", Msg: fmt.Sprintf("Waiting for %v due to consecutive empty responses", waitTime)}
 				p.logger.Log(&lm)
 
-				time.Sleep(waitTime)        // Wait for the specified time before retrying
+				p.metrics.Backoff.Set(waitTime.Seconds())
+				p.update(func(s *Status) { s.BackoffSeconds = waitTime.Seconds() })
+
+				p.sleep(waitTime)           // Wait for the specified time before retrying
 				waitTime += initialWaitTime // Increase the wait time linearly
 			}
 
-			if emptyResponseCount >= maxConsecutiveEmptyResponses {
+			if emptyResponseCount >= p.maxConsecutiveEmptyResponses {
 				lm = log.Message{Level: "INFO", Msg: fmt.Sprintf("Reached max consecutive empty responses, canceling context")}
 				p.logger.Log(&lm)
 
@@ -73,3 +148,105 @@ func (p *transformer) Worker() {
 		}
 	}
 }
+
+// Pause stops polling after the batch in flight.
+func (p *transformer) Pause() error {
+	return p.transition(StatePaused, StateRunning)
+}
+
+// Resume continues polling after a pause.
+func (p *transformer) Resume() error {
+	return p.transition(StateRunning, StatePaused)
+}
+
+// Drain lets the batch in flight finish and then stops the worker, Worker returns once it is drained.
+func (p *transformer) Drain() error {
+	return p.transition(StateDraining, StateRunning, StatePaused, StateDraining)
+}
+
+// State returns the current state of the worker.
+func (p *transformer) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.status.State
+}
+
+// Status returns a snapshot of the worker.
+func (p *transformer) Status() Status {
+	p.mu.Lock()
+	status := p.status
+	p.mu.Unlock()
+
+	status.MaxMessages, status.MaxWaitTime = p.extractor.Polling()
+
+	return status
+}
+
+// SetPolling changes how many messages are asked for per poll and how long, in seconds, a poll waits for messages.
+// SQS accepts at most 10 messages and 20 seconds, the message limit is left to the queue since LocalStack allows more.
+func (p *transformer) SetPolling(maxMessages, maxWaitTime int32) error {
+	if maxMessages < 1 {
+		return errors.New("max_messages must be at least 1")
+	}
+
+	if maxWaitTime < 0 || maxWaitTime > 20 {
+		return errors.New("max_wait_time must be between 0 and 20")
+	}
+
+	p.extractor.SetPolling(maxMessages, maxWaitTime)
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Polling changed to %d messages and %d seconds wait time", maxMessages, maxWaitTime)}
+	p.logger.Log(&lm)
+
+	return nil
+}
+
+// transition moves the worker to the given state if it is in one of the states from, or from any state when none are given.
+func (p *transformer) transition(to State, from ...State) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	allowed := len(from) == 0
+	for _, state := range from {
+		allowed = allowed || p.status.State == state
+	}
+
+	if !allowed {
+		return ErrInvalidTransition
+	}
+
+	if p.status.State != to {
+		lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Worker moved from %v to %v", p.status.State, to)}
+		p.logger.Log(&lm)
+	}
+
+	p.status.State = to
+
+	// Wake the worker if it is paused or backing off.
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// update changes the counters of the status.
+func (p *transformer) update(fn func(s *Status)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fn(&p.status)
+}
+
+// sleep waits for d, or less when the state changes, so a pause or drain does not wait for the backoff.
+func (p *transformer) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-p.wake:
+	}
+}
@@ -2,73 +2,108 @@ package etl
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"net/url"
 )
 
+// deleteBatchSize is the most messages SQS deletes per request.
+const deleteBatchSize = 10
+
 type sqsActions struct {
 	sqsClient   *sqs.Client
 	logger      *log.CustomLogger
 	sqsEndpoint string
 }
 
-func NewSQSClient(l *log.CustomLogger, sqsEndpoint string) (ISQSWrapper, error) {
-	sdkConfig, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "ls-YAfoFAQe-5538-DENO-0592-KidEpibE1a33")))
+// NewSQSClient returns the SQS client of the queue at sqsEndpoint, signed in with the credential source of the AWS
+// settings. The endpoint override, e.g. LocalStack, replaces the regional endpoint of AWS.
+func NewSQSClient(l *log.CustomLogger, sqsEndpoint string, settings config.AWS) (ISQSWrapper, error) {
+	sdkConfig, err := loadAWSConfig(context.TODO(), l, settings)
 	if err != nil {
 		return nil, err
 	}
 
 	sc := sqs.NewFromConfig(sdkConfig, func(o *sqs.Options) {
-		o.BaseEndpoint = aws.String(sqsEndpoint)
-		o.Credentials = credentials.NewStaticCredentialsProvider("test", "test", "ls-YAfoFAQe-5538-DENO-0592-KidEpibE1a33")
+		if settings.Endpoint != "" {
+			o.BaseEndpoint = aws.String(settings.Endpoint)
+		}
 	})
+
+	// The SDK takes the queue URL, query API parameters of older configurations such as ?Action=ReceiveMessage are dropped.
+	queueURL, err := url.Parse(sqsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	queueURL.RawQuery = ""
+
 	return &sqsActions{
 		sqsClient:   sc,
 		logger:      l,
-		sqsEndpoint: sqsEndpoint,
+		sqsEndpoint: queueURL.String(),
 	}, nil
 }
 
-// DeleteMessages uses the DeleteMessageBatch action to delete a batch of messages from an Amazon SQS queue.
+// DeleteMessages uses the DeleteMessageBatch action to delete a batch of messages from an Amazon SQS queue, in
+// requests of at most deleteBatchSize messages.
 func (actor sqsActions) DeleteMessages(messages []*model.Response) error {
-	entries := make([]types.DeleteMessageBatchRequestEntry, len(messages))
-	for msgIndex, msg := range messages {
-		entries[msgIndex].Id = aws.String(fmt.Sprintf("%v", msgIndex))
-		entries[msgIndex].ReceiptHandle = &msg.ReceiptHandle
-	}
+	for start := 0; start < len(messages); start += deleteBatchSize {
+		chunk := messages[start:min(start+deleteBatchSize, len(messages))]
 
-	//TODO: Use context.Todo()
-	_, err := actor.sqsClient.DeleteMessageBatch(context.TODO(), &sqs.DeleteMessageBatchInput{
-		Entries:  entries,
-		QueueUrl: aws.String(actor.sqsEndpoint),
-	})
-	if err != nil {
-		return err
+		entries := make([]types.DeleteMessageBatchRequestEntry, len(chunk))
+		for msgIndex, msg := range chunk {
+			entries[msgIndex].Id = aws.String(fmt.Sprintf("%v", msgIndex))
+			entries[msgIndex].ReceiptHandle = &msg.ReceiptHandle
+		}
+
+		//TODO: Use context.Todo()
+		result, err := actor.sqsClient.DeleteMessageBatch(context.TODO(), &sqs.DeleteMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: aws.String(actor.sqsEndpoint),
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(result.Failed) > 0 {
+			return errors.New(fmt.Sprintf("Error deleting %d of %d messages: %v", len(result.Failed), len(chunk), aws.ToString(result.Failed[0].Message)))
+		}
 	}
 
 	return nil
 }
 
-// GetMessages uses the ReceiveMessage action to get messages from an Amazon SQS queue.
-func (actor sqsActions) GetMessages(maxMessages int32, waitTime int32) ([]types.Message, error) {
-	var messages []types.Message
-	result, err := actor.sqsClient.ReceiveMessage(context.TODO(), &sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(actor.sqsEndpoint),
-		MaxNumberOfMessages: maxMessages,
-		WaitTimeSeconds:     waitTime,
+// GetMessages uses the ReceiveMessage action to get messages from an Amazon SQS queue, with the time each was sent.
+func (actor sqsActions) GetMessages(ctx context.Context, maxMessages int32, waitTime int32) ([]types.Message, error) {
+	result, err := actor.sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:                    aws.String(actor.sqsEndpoint),
+		MaxNumberOfMessages:         maxMessages,
+		WaitTimeSeconds:             waitTime,
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameSentTimestamp},
 	})
 	if err != nil {
-		fmt.Printf("Couldn't get messages from queue %v. Here's why: %v\n", actor.sqsEndpoint, err)
-	} else {
-		messages = result.Messages
+		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error receiving messages from queue %v: %v", actor.sqsEndpoint, err.Error())}
+		actor.logger.Log(&lm)
+
+		return nil, err
 	}
-	return messages, err
+
+	return result.Messages, nil
+}
+
+// Ping checks that the queue answers, by asking for its ARN instead of receiving messages.
+func (actor sqsActions) Ping(ctx context.Context) error {
+	_, err := actor.sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(actor.sqsEndpoint),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+
+	return err
 }
@@ -0,0 +1,168 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report is the result of every check, "ok" or the error of the check.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Ready reports whether every check passed.
+func (r Report) Ready() bool {
+	return r.Status == "ready"
+}
+
+type checker struct {
+	logger  *log.CustomLogger
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+// New returns a Health without checks. Each check gets timeout to complete.
+func New(logger *log.CustomLogger, timeout time.Duration) Health {
+	return &checker{
+		logger:  logger,
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Add registers a check run on every readiness probe.
+func (c *checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = check
+}
+
+// Ready runs all checks concurrently.
+func (c *checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	report := Report{Status: "ready", Checks: make(map[string]string, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			result := "ok"
+			if err := run(checkCtx, check); err != nil {
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[name] = result
+			if result != "ok" {
+				report.Status = "unavailable"
+			}
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+// run runs the check, a check that panics fails instead of crashing the process.
+func run(ctx context.Context, check Check) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("check panicked: %v", r))
+		}
+	}()
+
+	return check(ctx)
+}
+
+// WaitReady runs the checks until they all pass, at most maxAttempts times with interval in between.
+func (c *checker) WaitReady(ctx context.Context, maxAttempts int, interval time.Duration) error {
+	var report Report
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		report = c.Ready(ctx)
+		if report.Ready() {
+			return nil
+		}
+
+		lm := log.Message{Level: "WARN", Msg: fmt.Sprintf("Dependencies not ready (attempt %d of %d): %v", attempt, maxAttempts, report.failures())}
+		c.logger.Log(&lm)
+
+		if attempt == maxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	return errors.New(fmt.Sprintf("dependencies not ready after %d attempts: %v", maxAttempts, report.failures()))
+}
+
+// Healthz reports that the process is alive, it does not check any dependency.
+func (c *checker) Healthz(w http.ResponseWriter, _ *http.Request) {
+	respJson, _ := json.Marshal(map[string]string{"status": "ok"})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(respJson)
+}
+
+// Readyz runs the checks and answers 200 when they all pass and 503 otherwise.
+func (c *checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := c.Ready(r.Context())
+
+	statusCode := http.StatusOK
+	if !report.Ready() {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	respJson, _ := json.Marshal(report)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(respJson)
+}
+
+// failures lists the failed checks.
+func (r Report) failures() string {
+	var failures []string
+	for name, result := range r.Checks {
+		if result != "ok" {
+			failures = append(failures, fmt.Sprintf("%v: %v", name, result))
+		}
+	}
+
+	sort.Strings(failures)
+
+	return strings.Join(failures, "; ")
+}
@@ -0,0 +1,18 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Check reports whether a dependency is usable. It must give up once the context is done.
+type Check func(ctx context.Context) error
+
+type Health interface {
+	Add(name string, check Check)
+	Ready(ctx context.Context) Report
+	WaitReady(ctx context.Context, maxAttempts int, interval time.Duration) error
+	Healthz(w http.ResponseWriter, r *http.Request)
+	Readyz(w http.ResponseWriter, r *http.Request)
+}
@@ -1,11 +0,0 @@
--- init.sql
-
-CREATE TABLE IF NOT EXISTS user_logins(
-    user_id varchar(128),
-    device_type varchar(32),
-    masked_ip varchar(256),
-    masked_device_id varchar(256),
-    locale varchar(32),
-    app_version varchar(10),
-    create_date date
-);
\ No newline at end of file
@@ -0,0 +1,10 @@
+package keystore
+
+import "context"
+
+type KeyStore interface {
+	DataKey(ctx context.Context, userID string) (string, error)
+	Lookup(ctx context.Context, userID string) (string, error)
+	Erase(ctx context.Context, userID string) (int64, error)
+	Check(ctx context.Context) error
+}
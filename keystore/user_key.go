@@ -0,0 +1,214 @@
+package keystore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"strings"
+)
+
+// ErrKeyShredded is returned when a user's data key no longer exists, i.e. the user has been erased.
+var ErrKeyShredded = errors.New("data key has been shredded")
+
+type userKeys struct {
+	dbConn    *sql.DB
+	masterKey string
+	policy    model.MaskingPolicy
+}
+
+// New returns a KeyStore that keeps per-user data keys in the user_data_keys table, wrapped with the master key.
+// The masking policy tells which columns Erase clears.
+func New(dbConn *sql.DB, masterKey string, policy model.MaskingPolicy) KeyStore {
+	return &userKeys{
+		dbConn:    dbConn,
+		masterKey: masterKey,
+		policy:    policy,
+	}
+}
+
+// DataKey returns the data key of the given user, generating and storing a new one if the user has none yet.
+// Keys are looked up first, a key is only generated and wrapped for users seen for the first time.
+func (uk *userKeys) DataKey(ctx context.Context, userID string) (string, error) {
+	key, err := uk.Lookup(ctx, userID)
+	if !errors.Is(err, ErrKeyShredded) {
+		return key, err
+	}
+
+	rawKey := make([]byte, 16)
+	if _, err := rand.Read(rawKey); err != nil {
+		return "", err
+	}
+
+	// The hex encoded key is 32 bytes long and is used as an AES-256 key.
+	wrappedKey, err := model.Encrypt(hex.EncodeToString(rawKey), uk.masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep the existing key if another writer created one first.
+	_, err = uk.dbConn.ExecContext(ctx, log.Traced(ctx, "INSERT INTO user_data_keys (user_id, wrapped_key) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING"), userID, *wrappedKey)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error storing data key: %v", err.Error()))
+	}
+
+	return uk.Lookup(ctx, userID)
+}
+
+// Lookup returns the data key of the given user or ErrKeyShredded if the user has no key.
+func (uk *userKeys) Lookup(ctx context.Context, userID string) (string, error) {
+	var wrappedKey string
+
+	err := uk.dbConn.QueryRowContext(ctx, log.Traced(ctx, "SELECT wrapped_key FROM user_data_keys WHERE user_id = $1"), userID).Scan(&wrappedKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrKeyShredded
+	}
+
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error fetching data key: %v", err.Error()))
+	}
+
+	return uk.unwrap(wrappedKey)
+}
+
+// unwrap decrypts a wrapped data key with the master key. It fails when the key was wrapped with another master key,
+// which may decrypt without an error by chance but does not give a hex encoded key of 32 bytes.
+func (uk *userKeys) unwrap(wrappedKey string) (string, error) {
+	key, err := model.Decrypt(wrappedKey, uk.masterKey)
+	if err != nil {
+		return "", errors.New("stored data keys do not unwrap with the master key")
+	}
+
+	if _, err = hex.DecodeString(*key); err != nil || len(*key) != 32 {
+		return "", errors.New("stored data keys do not unwrap with the master key")
+	}
+
+	return *key, nil
+}
+
+// fieldColumns are the user_logins columns of the fields a masking policy applies to, user_id is always passed through.
+var fieldColumns = map[string]string{"app_version": "app_version", "device_type": "device_type", "ip": "masked_ip", "locale": "locale", "device_id": "masked_device_id"}
+
+// Erase destroys the data key and the tokens of the given user, marks all of their rows as shredded and clears what
+// would still reveal something about them:
+//   - the IP and device ID, and every other field the policy masks, such as hashed, truncated or partial values,
+//   - IP pseudonyms, which are keyed with the master key so that subnets can be grouped across users.
+//
+// Encrypted values of rows with a per-user key are kept, they can no longer be decrypted. Partitions detached by the
+// retention job are erased as well, archive files are not, see the README.
+func (uk *userKeys) Erase(ctx context.Context, userID string) (int64, error) {
+	tx, err := uk.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, log.Traced(ctx, "DELETE FROM user_data_keys WHERE user_id = $1"), userID)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error deleting data key: %v", err.Error()))
+	}
+
+	// What the user's tokens stand for is deleted, and so are the tokens no other user sent. The statement sees the
+	// vault before the user's entries are deleted, so their own entries are left out of the check.
+	_, err = tx.ExecContext(ctx, log.Traced(ctx, "WITH erased AS (DELETE FROM pii_vault_users WHERE user_id = $1 RETURNING token) DELETE FROM pii_vault v USING erased e WHERE v.token = e.token AND v.ciphertext IS NULL AND NOT EXISTS (SELECT 1 FROM pii_vault_users u WHERE u.token = v.token AND u.user_id <> $1)"), userID)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error deleting tokens: %v", err.Error()))
+	}
+
+	tables, err := detachedPartitions(ctx, tx)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error listing detached partitions: %v", err.Error()))
+	}
+
+	var shredded int64
+	for _, table := range append([]string{"user_logins"}, tables...) {
+		res, err := tx.ExecContext(ctx, log.Traced(ctx, fmt.Sprintf("UPDATE %s SET %s WHERE user_id = $1 AND NOT shredded", pq.QuoteIdentifier(table), uk.shredColumns())), userID)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Error shredding records of %v: %v", table, err.Error()))
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+
+		shredded += n
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return shredded, nil
+}
+
+// shredColumns returns the assignments shredding a row. The IP and device ID are always cleared, other fields only
+// when the policy masks them.
+func (uk *userKeys) shredColumns() string {
+	assignments := []string{"shredded = true", "ip_pseudonym = NULL"}
+
+	for _, field := range model.MaskedFields {
+		column, ok := fieldColumns[field]
+		if !ok {
+			continue
+		}
+
+		method := uk.policy.Method(field)
+		switch {
+		case method == model.MethodEncrypt:
+			assignments = append(assignments, fmt.Sprintf("%[1]s = CASE WHEN per_user_key THEN %[1]s END", column))
+		case method != model.MethodPassthrough || field == "ip" || field == "device_id":
+			assignments = append(assignments, column+" = NULL")
+		}
+	}
+
+	return strings.Join(assignments, ", ")
+}
+
+// detachedPartitions returns the monthly partitions of user_logins the retention job detached, they are tables of
+// their own and keep the rows of erased users otherwise.
+func detachedPartitions(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, log.Traced(ctx, "SELECT relname FROM pg_class WHERE relkind = 'r' AND NOT relispartition AND relnamespace = current_schema()::regnamespace AND relname ~ '^user_logins_p[0-9]{6}$'"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
+
+// Check reports whether the key table is reachable and a stored data key unwraps with the master key, which fails
+// when the service was started with a different master key than the one the keys were wrapped with.
+func (uk *userKeys) Check(ctx context.Context) error {
+	var wrappedKey string
+
+	err := uk.dbConn.QueryRowContext(ctx, log.Traced(ctx, "SELECT wrapped_key FROM user_data_keys LIMIT 1")).Scan(&wrappedKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = uk.unwrap(wrappedKey)
+
+	return err
+}
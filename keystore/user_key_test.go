@@ -0,0 +1,129 @@
+package keystore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"io"
+	"testing"
+)
+
+// wrappedKeyDriver is a database driver answering every query with one wrapped_key row.
+type wrappedKeyDriver struct {
+	wrappedKey string
+}
+
+func (d wrappedKeyDriver) Open(string) (driver.Conn, error) {
+	return wrappedKeyConn(d), nil
+}
+
+type wrappedKeyConn wrappedKeyDriver
+
+func (c wrappedKeyConn) Prepare(string) (driver.Stmt, error) {
+	return wrappedKeyStmt(c), nil
+}
+
+func (c wrappedKeyConn) Close() error {
+	return nil
+}
+
+func (c wrappedKeyConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type wrappedKeyStmt wrappedKeyDriver
+
+func (s wrappedKeyStmt) Close() error {
+	return nil
+}
+
+func (s wrappedKeyStmt) NumInput() int {
+	return -1
+}
+
+func (s wrappedKeyStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("statements are not supported")
+}
+
+func (s wrappedKeyStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &wrappedKeyRows{wrappedKey: s.wrappedKey}, nil
+}
+
+type wrappedKeyRows struct {
+	wrappedKey string
+	done       bool
+}
+
+func (r *wrappedKeyRows) Columns() []string {
+	return []string{"wrapped_key"}
+}
+
+func (r *wrappedKeyRows) Close() error {
+	return nil
+}
+
+func (r *wrappedKeyRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	r.done = true
+	dest[0] = r.wrappedKey
+
+	return nil
+}
+
+type connector struct {
+	d wrappedKeyDriver
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return c.d.Open("")
+}
+
+func (c connector) Driver() driver.Driver {
+	return c.d
+}
+
+// randomKey returns a random hex encoded key of 32 bytes, like a data key.
+func randomKey(t *testing.T) string {
+	rawKey := make([]byte, 16)
+	if _, err := rand.Read(rawKey); err != nil {
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(rawKey)
+}
+
+// TestCheckWrongMasterKey wraps data keys with one master key and checks them with another, which must fail without
+// panicking, whatever the wrong key decrypts the padding to.
+func TestCheckWrongMasterKey(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		masterKey, wrongKey := randomKey(t), randomKey(t)
+
+		wrappedKey, err := model.Encrypt(randomKey(t), masterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dbConn := sql.OpenDB(connector{wrappedKeyDriver{wrappedKey: *wrappedKey}})
+
+		if err = New(dbConn, masterKey, model.MaskingPolicy{}).Check(context.Background()); err != nil {
+			t.Errorf("Check with the right master key: %v", err)
+		}
+
+		if err = New(dbConn, wrongKey, model.MaskingPolicy{}).Check(context.Background()); err == nil {
+			t.Errorf("Check with a wrong master key passed")
+		}
+
+		if _, err = New(dbConn, wrongKey, model.MaskingPolicy{}).Lookup(context.Background(), "user"); err == nil {
+			t.Errorf("Lookup with a wrong master key passed")
+		}
+
+		_ = dbConn.Close()
+	}
+}
@@ -0,0 +1,62 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type traceIDKey struct{}
+
+// validTraceID matches trace IDs accepted from callers, they end up in logs and SQL comments.
+var validTraceID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// traceParent matches a W3C traceparent header, the second part is the trace ID.
+var traceParent = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// NewTraceID returns a random trace ID.
+func NewTraceID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// ParseTraceID returns the trace ID of an X-Request-ID or traceparent header value, or an empty string when neither
+// holds a valid one.
+func ParseTraceID(requestID, traceparent string) string {
+	if validTraceID.MatchString(requestID) {
+		return requestID
+	}
+
+	if m := traceParent.FindStringSubmatch(strings.TrimSpace(traceparent)); m != nil && strings.Trim(m[1], "0") != "" {
+		return m[1]
+	}
+
+	return ""
+}
+
+// WithTraceID returns a context carrying the trace ID.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the trace ID of the context, or an empty string.
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// Traced prefixes the statement with the trace ID of the request as an SQL comment, so slow or failing statements in
+// pg_stat_activity and the database logs can be tied to the request or batch that ran them.
+func Traced(ctx context.Context, statement string) string {
+	traceID := TraceID(ctx)
+	if traceID == "" {
+		return statement
+	}
+
+	return fmt.Sprintf("/* trace_id=%s */ %s", traceID, statement)
+}
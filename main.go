@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/database"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/etl"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/health"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/internal/metrics"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/keystore"
 	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
-	_ "github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/migrations"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/partitions"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/vault"
+	"net/http"
 	"os"
-	"strconv"
-	_ "time"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -23,12 +34,6 @@ func init() {
 }
 
 func main() {
-	// Retrieve the number of workers and batch size from environment variables and convert them to integers.
-	maxMassagesToPoll, _ := strconv.Atoi(os.Getenv("MAX_MESSAGES"))
-	maxWaitTimeToPoll, _ := strconv.Atoi(os.Getenv("MAX_WAIT_TIME"))
-	sqsEndpoint := os.Getenv("SQS_ENDPOINT")
-	encryptionKey := os.Getenv("ENCRYPTION_SECRET")
-
 	// Initialize Logger
 	logger, err := log.NewCustomLogger("logs")
 	if err != nil {
@@ -39,10 +44,39 @@ func main() {
 	lm := log.Message{Level: "INFO", Msg: "Logger initialized successfully"}
 	logger.Log(&lm)
 
+	// Settings come from the config file, the environment and the flags before the command, e.g.
+	// `dataops-takehome -config etl.yaml -sqs.max_messages 10`. Every invalid setting is reported at once.
+	cfg, args, err := config.Load(config.ETL, os.Args[1:])
+	if err == flag.ErrHelp {
+		fmt.Fprint(os.Stderr, usage+config.Usage())
+		return
+	}
+
+	if err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+		logger.Log(&lm)
+
+		os.Exit(2)
+	}
+
+	// `config print` needs no database.
+	if len(args) > 0 && args[0] == "config" {
+		runConfigCommand(logger, cfg, args)
+		return
+	}
+
+	// Load the masking policy, the default policy encrypts the IP and device ID.
+	maskingPolicy, err := model.LoadMaskingPolicy(cfg.Masking.PolicyFile)
+	if err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Loading masking policy failed with error %v", err.Error())}
+		logger.Log(&lm)
+
+		return
+	}
+
 	// Initialize a new database connection.
-	db := database.New(logger)
+	db := database.New(logger, cfg.DB)
 	dbConn, err := db.Open()
-	defer dbConn.Close()
 	if err != nil {
 		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Initiating database failed with error %v", err.Error())}
 		logger.Log(&lm)
@@ -50,11 +84,41 @@ func main() {
 		return
 	}
 
-	lm = log.Message{Level: "INFO", Msg: fmt.Sprintf("Database initilized sucessfully.")}
-	logger.Log(&lm)
+	defer dbConn.Close()
+
+	// Open already waited for the database to answer, within db.connect_max_attempts. Readiness then waits, within
+	// its own startup.max_attempts, for everything else such as the keys and the schema, and keeps serving /readyz.
+	checker := health.New(logger, 2*time.Second)
+	checker.Add("postgres", dbConn.PingContext)
+
+	// Run a one-off command such as `dataops-takehome erase <user_id>` instead of the ETL.
+	if len(args) > 0 {
+		if err = checker.WaitReady(context.Background(), cfg.Startup.MaxAttempts, cfg.Startup.RetryInterval); err != nil {
+			lm = log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+			logger.Log(&lm)
+
+			return
+		}
+
+		runCommand(logger, dbConn, cfg, maskingPolicy, args)
+		return
+	}
+
+	// The commands do not mask records, so only the ETL needs a valid encryption key.
+	checker.Add("encryption_key", func(context.Context) error { return model.CheckKey(cfg.Masking.EncryptionSecret) })
+
+	// The ETL waits until `migrate up` brought the schema to the version it was built for.
+	checker.Add("schema", migrations.New(logger, dbConn).Check)
+
+	// Per-user data keys are only used when enabled, otherwise every record is encrypted with the encryption key.
+	var keyStore keystore.KeyStore
+	if cfg.Masking.PerUserKeys {
+		keyStore = keystore.New(dbConn, cfg.Masking.EncryptionSecret, maskingPolicy)
+		checker.Add("keystore", keyStore.Check)
+	}
 
 	// Initialize the ETL components.
-	sqsClient, err := etl.NewSQSClient(logger, sqsEndpoint)
+	sqsClient, err := etl.NewSQSClient(logger, cfg.SQS.Endpoint, cfg.AWS)
 	if err != nil {
 		lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Error initilizing sqs client: %v", err.Error())}
 		logger.Log(&lm)
@@ -62,13 +126,86 @@ func main() {
 		return
 	}
 
-	extractor := etl.NewExtracter(logger, encryptionKey, sqsEndpoint, int32(maxMassagesToPoll), int32(maxWaitTimeToPoll))
-	loader := etl.NewLoader(logger, dbConn, sqsClient)
-	processor := etl.NewProcessor(logger, extractor, loader)
+	registry := metrics.NewRegistry()
+	etlMetrics := etl.NewMetrics(registry)
+	database.RegisterPoolMetrics(registry, dbConn)
+
+	tokenVault := vault.New(dbConn, cfg.Masking.EncryptionSecret)
+	extractor := etl.NewExtracter(logger, etlMetrics, cfg.Masking.EncryptionSecret, keyStore, maskingPolicy, tokenVault, sqsClient, cfg.SQS.MaxMessages, cfg.SQS.MaxWaitTime)
+
+	// Postgres is the primary sink, the file sink writes the same records to hourly files when configured.
+	var secondarySinks []etl.Sink
+	if cfg.Sinks.FileDir != "" {
+		fileSink, err := etl.NewFileSink(logger, etlMetrics, cfg.Sinks.FileDir, cfg.Sinks.FileFormat)
+		if err != nil {
+			lm = log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+			logger.Log(&lm)
+
+			return
+		}
+
+		secondarySinks = append(secondarySinks, etl.Sink{Name: "file", Loader: fileSink})
+	}
+
+	primarySink := etl.Sink{Name: "postgres", Loader: etl.NewLoader(logger, etlMetrics, dbConn)}
+	loader := etl.NewFanOutLoader(logger, etlMetrics, sqsClient, cfg.Sinks.Ack == config.AckAll, primarySink, secondarySinks...)
+	processor := etl.NewProcessor(logger, etlMetrics, extractor, loader, cfg.Pipeline.MaxNoResponses, cfg.Pipeline.MaxConsecutiveNoResponses)
+
+	checker.Add("sqs", extractor.Ping)
+
+	// Serve metrics, health checks and the control plane while the pipeline runs, also while waiting for the dependencies.
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry.Handler())
+		mux.HandleFunc("/healthz", checker.Healthz)
+		mux.HandleFunc("/readyz", checker.Readyz)
+		if err := etl.NewControl(logger, processor, cfg.Pipeline.AdminToken).Register(mux); err != nil {
+			lm := log.Message{Level: "WARN", Msg: fmt.Sprintf("Control plane disabled: %v", err.Error())}
+			logger.Log(&lm)
+		}
+
+		lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Metrics, health and control server starting to listen on port %v", cfg.Pipeline.Port)}
+		logger.Log(&lm)
+
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Pipeline.Port), mux); err != nil {
+			lm = log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Initializing metrics and health server to listen on port %v with error %v", cfg.Pipeline.Port, err.Error())}
+			logger.Log(&lm)
+		}
+	}()
+
+	if err = checker.WaitReady(context.Background(), cfg.Startup.MaxAttempts, cfg.Startup.RetryInterval); err != nil {
+		lm = log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+		logger.Log(&lm)
+
+		return
+	}
+
+	lm = log.Message{Level: "INFO", Msg: "Database, queue and keys are ready."}
+	logger.Log(&lm)
+
+	// Keep the monthly partitions of user_logins ahead of the loads and within the retention.
+	go partitions.New(logger, dbConn, cfg.Partitions).Run(context.Background())
+
+	// SIGTERM and SIGINT drain the worker, the batch in flight is loaded before exiting. Its messages are deleted from the
+	// queue when the sinks required by sinks.ack loaded it, otherwise they are delivered again after the visibility timeout.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+
+		lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Received %v, draining the worker", sig)}
+		logger.Log(&lm)
+
+		_ = processor.Drain()
+	}()
 
 	// Start extraction and loading data
 	processor.Worker()
 
+	// Complete the files of the sinks once the worker stopped.
+	_ = loader.Close()
+
 	lm = log.Message{Level: "INFO", Msg: fmt.Sprintf("SQS Client, Extractor, Loader, Processor initilized sucessfully.")}
 	logger.Log(&lm)
 }
@@ -0,0 +1,15 @@
+package migrations
+
+import "context"
+
+// Migrator applies the embedded migrations to the database and reports its schema version.
+type Migrator interface {
+	// Up applies every pending migration in order and returns the ones applied.
+	Up(ctx context.Context) ([]Migration, error)
+	// Down reverts the last steps applied migrations and returns the ones reverted.
+	Down(ctx context.Context, steps int) ([]Migration, error)
+	// Status lists every migration with the time it was applied, if it was.
+	Status(ctx context.Context) ([]Status, error)
+	// Check fails while the database is behind the latest migration.
+	Check(ctx context.Context) error
+}
@@ -0,0 +1,303 @@
+// Package migrations versions the database schema with the ordered up and down SQL files in sql/, named
+// <version>_<name>.up.sql and <version>_<name>.down.sql and embedded in the binaries.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey is the Postgres advisory lock held while migrating, so services starting together migrate one at a time.
+const lockKey = 7_160_042_047
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one schema change and how to revert it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, AppliedAt is nil while it is pending.
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type migrator struct {
+	logger     *log.CustomLogger
+	dbConn     *sql.DB
+	migrations []Migration
+}
+
+// New returns a Migrator of the embedded migrations. It panics if they are malformed, which is a build mistake.
+func New(logger *log.CustomLogger, dbConn *sql.DB) Migrator {
+	migrations, err := load(files)
+	if err != nil {
+		panic(err)
+	}
+
+	return &migrator{
+		logger:     logger,
+		dbConn:     dbConn,
+		migrations: migrations,
+	}
+}
+
+// load reads the migrations in version order. Every version needs an up and a down file.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, errors.New(fmt.Sprintf("migration %v is not named <version>_<name>.up|down.sql", entry.Name()))
+		}
+
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := fs.ReadFile(fsys, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, errors.New(fmt.Sprintf("migration %d is named both %v and %v", version, m.Name, match[2]))
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, errors.New(fmt.Sprintf("migration %d_%v needs an up and a down file", m.Version, m.Name))
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// latest returns the version of the last embedded migration.
+func (m *migrator) latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			if err = m.apply(ctx, conn, migration, true); err != nil {
+				return err
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+func (m *migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			if err = m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+func (m *migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := Status{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Check compares the highest applied version with the latest embedded one. A newer schema is accepted, so that a
+// service can still start while another one is rolled out with more migrations.
+func (m *migrator) Check(ctx context.Context) error {
+	var version int
+
+	err := m.dbConn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return errors.New(fmt.Sprintf("reading the schema version, run `dataops-takehome migrate up`: %v", err.Error()))
+	}
+
+	if version < m.latest() {
+		return errors.New(fmt.Sprintf("schema version %d is behind %d, run `dataops-takehome migrate up`", version, m.latest()))
+	}
+
+	return nil
+}
+
+// locked runs fn on a connection holding the advisory lock, after creating schema_migrations if needed.
+func (m *migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.dbConn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return errors.New(fmt.Sprintf("Error taking the migration lock: %v", err.Error()))
+	}
+
+	// The lock belongs to the session, it is released even if ctx is already canceled.
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations(
+    version bigint PRIMARY KEY,
+    name varchar(128) NOT NULL,
+    applied_at timestamp NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+)`)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating schema_migrations: %v", err.Error()))
+	}
+
+	return fn(conn)
+}
+
+// apply runs the up or down SQL of the migration and records it in schema_migrations in one transaction.
+func (m *migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	start := time.Now()
+
+	statements, record, args, done := migration.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", []interface{}{migration.Version, migration.Name}, "applied"
+	if !up {
+		statements, record, args, done = migration.Down, "DELETE FROM schema_migrations WHERE version = $1", []interface{}{migration.Version}, "reverted"
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, statements); err != nil {
+		return errors.New(fmt.Sprintf("Error migrating %d_%v: %v", migration.Version, migration.Name, err.Error()))
+	}
+
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return errors.New(fmt.Sprintf("Error recording %d_%v: %v", migration.Version, migration.Name, err.Error()))
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Migration %d_%v %v in %v", migration.Version, migration.Name, done, time.Since(start).Round(time.Millisecond))}
+	m.logger.Log(&lm)
+
+	return nil
+}
+
+// appliedVersions returns when each applied version was applied.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
@@ -0,0 +1,130 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// baselineSchema is the first init.sql, which created the databases the migrations must adopt.
+const baselineSchema = `CREATE TABLE IF NOT EXISTS user_logins(
+    user_id varchar(128),
+    device_type varchar(32),
+    masked_ip varchar(256),
+    masked_device_id varchar(256),
+    locale varchar(32),
+    app_version varchar(10),
+    create_date date
+);`
+
+// testDatabase returns a connection to a new schema of the database of MIGRATIONS_TEST_DATABASE_URL, which is dropped
+// when the test ends. The test is skipped without a database.
+func testDatabase(t *testing.T) *sql.DB {
+	dsn := os.Getenv("MIGRATIONS_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("MIGRATIONS_TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = admin.Close() })
+
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err = admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// Every connection of the pool uses the new schema.
+	switch {
+	case strings.Contains(dsn, "://") && strings.Contains(dsn, "?"):
+		dsn += "&search_path=" + schema
+	case strings.Contains(dsn, "://"):
+		dsn += "?search_path=" + schema
+	default:
+		dsn += " search_path=" + schema
+	}
+
+	dbConn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = dbConn.Close() })
+
+	return dbConn
+}
+
+// TestUpFromBaselineSchema migrates a database created by the first init.sql, with logins in it, then reverts and
+// applies every migration again.
+func TestUpFromBaselineSchema(t *testing.T) {
+	ctx := context.Background()
+	dbConn := testDatabase(t)
+
+	logger, err := log.NewCustomLogger(filepath.Join(t.TempDir(), "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = dbConn.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dbConn.Exec("INSERT INTO user_logins (user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date) VALUES ('u1', 'ios', 'ip', 'device', 'en_US', '2.3.0', '2024-06-01'), ('u2', 'android', 'ip', 'device', NULL, '0.96', NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := New(logger, dbConn)
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("migrating the baseline schema: %v", err)
+	}
+
+	if err = migrator.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var logins, ids int
+	if err = dbConn.QueryRow("SELECT COUNT(*), COUNT(DISTINCT id) FROM user_logins WHERE NOT shredded AND NOT per_user_key").Scan(&logins, &ids); err != nil {
+		t.Fatal(err)
+	}
+
+	if logins != 2 || ids != 2 {
+		t.Errorf("got %d logins with %d ids after migrating, want 2 with 2", logins, ids)
+	}
+
+	reverted, err := migrator.Down(ctx, len(applied))
+	if err != nil {
+		t.Fatalf("reverting the migrations: %v", err)
+	}
+
+	if len(reverted) != len(applied) {
+		t.Errorf("reverted %d migrations, want %d", len(reverted), len(applied))
+	}
+
+	var table sql.NullString
+	if err = dbConn.QueryRow("SELECT to_regclass('user_logins')::text").Scan(&table); err != nil {
+		t.Fatal(err)
+	}
+
+	if table.Valid {
+		t.Errorf("user_logins exists after reverting every migration")
+	}
+
+	if _, err = migrator.Up(ctx); err != nil {
+		t.Fatalf("migrating an empty database: %v", err)
+	}
+}
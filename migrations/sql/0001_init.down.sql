@@ -0,0 +1,6 @@
+-- Drops the whole schema, including every login, data key, token and audit entry.
+DROP TABLE IF EXISTS pii_access_audit;
+DROP FUNCTION IF EXISTS pii_access_audit_append_only();
+DROP TABLE IF EXISTS pii_vault;
+DROP TABLE IF EXISTS user_data_keys;
+DROP TABLE IF EXISTS user_logins;
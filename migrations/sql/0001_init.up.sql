@@ -0,0 +1,96 @@
+-- The schema of init.sql. Every statement is idempotent, so databases created by any version of init.sql adopt it:
+-- the first init.sql created user_logins with the columns below, the columns added to it later are added here when
+-- they are missing, before the indexes on them are built.
+
+CREATE TABLE IF NOT EXISTS user_logins(
+    user_id varchar(128),
+    device_type varchar(32),
+    masked_ip varchar(256),
+    masked_device_id varchar(256),
+    locale varchar(32),
+    app_version varchar(10),
+    create_date date
+);
+
+-- Existing logins are numbered from the sequence, which databases of a later init.sql already have with the id.
+CREATE SEQUENCE IF NOT EXISTS user_logins_id_seq;
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS id bigint NOT NULL DEFAULT nextval('user_logins_id_seq') PRIMARY KEY;
+ALTER SEQUENCE user_logins_id_seq OWNED BY user_logins.id;
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS per_user_key boolean NOT NULL DEFAULT false;
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS shredded boolean NOT NULL DEFAULT false;
+ALTER TABLE user_logins ADD COLUMN IF NOT EXISTS ip_pseudonym inet;
+
+-- Supports keyset pagination in (create_date, id) order.
+CREATE INDEX IF NOT EXISTS user_logins_create_date_id_idx ON user_logins (create_date DESC, id DESC);
+
+-- Supports filtering logins by user.
+CREATE INDEX IF NOT EXISTS user_logins_user_id_idx ON user_logins (user_id);
+
+-- Support looking up logins by a masked IP or device ID.
+CREATE INDEX IF NOT EXISTS user_logins_masked_ip_idx ON user_logins (masked_ip);
+CREATE INDEX IF NOT EXISTS user_logins_masked_device_id_idx ON user_logins (masked_device_id);
+
+-- Supports containment queries on pseudonymous subnets.
+CREATE INDEX IF NOT EXISTS user_logins_ip_pseudonym_idx ON user_logins USING gist (ip_pseudonym inet_ops);
+
+-- Per-user data keys, wrapped with the master key. Deleting a row makes that user's PII unrecoverable.
+CREATE TABLE IF NOT EXISTS user_data_keys(
+    user_id varchar(128) PRIMARY KEY,
+    wrapped_key varchar(256) NOT NULL,
+    create_date timestamp NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+-- Token to ciphertext mapping of tokenized fields. The fingerprint is a keyed hash of the value, so the same input
+-- always maps to the same token. Only roles granted pii_vault_access may read it.
+CREATE TABLE IF NOT EXISTS pii_vault(
+    token varchar(32) PRIMARY KEY,
+    field varchar(32) NOT NULL,
+    fingerprint varchar(64) NOT NULL UNIQUE,
+    ciphertext varchar(256) NOT NULL,
+    create_date timestamp NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+REVOKE ALL ON pii_vault FROM PUBLIC;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = 'pii_vault_access') THEN
+        CREATE ROLE pii_vault_access NOLOGIN;
+    END IF;
+END
+$$;
+
+GRANT SELECT, INSERT ON pii_vault TO pii_vault_access;
+
+-- Append-only log of every request that revealed PII: who asked, why, with which filters and whose PII was returned.
+CREATE TABLE IF NOT EXISTS pii_access_audit(
+    id bigserial PRIMARY KEY,
+    request_id varchar(64) NOT NULL,
+    subject varchar(128) NOT NULL,
+    role varchar(32) NOT NULL,
+    auth_method varchar(16) NOT NULL,
+    endpoint varchar(256) NOT NULL,
+    reason varchar(512) NOT NULL,
+    filters jsonb NOT NULL,
+    user_ids varchar(128)[] NOT NULL,
+    create_date timestamp NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+-- Supports searching the audit log by time, caller and revealed user.
+CREATE INDEX IF NOT EXISTS pii_access_audit_create_date_id_idx ON pii_access_audit (create_date DESC, id DESC);
+CREATE INDEX IF NOT EXISTS pii_access_audit_subject_idx ON pii_access_audit (subject);
+CREATE INDEX IF NOT EXISTS pii_access_audit_user_ids_idx ON pii_access_audit USING gin (user_ids);
+
+-- Audit entries can not be changed or removed, not even by the owner of the table.
+CREATE OR REPLACE FUNCTION pii_access_audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'pii_access_audit is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER pii_access_audit_no_update BEFORE UPDATE OR DELETE ON pii_access_audit
+    FOR EACH ROW EXECUTE FUNCTION pii_access_audit_append_only();
+CREATE OR REPLACE TRIGGER pii_access_audit_no_truncate BEFORE TRUNCATE ON pii_access_audit
+    FOR EACH STATEMENT EXECUTE FUNCTION pii_access_audit_append_only();
+
+REVOKE UPDATE, DELETE, TRUNCATE ON pii_access_audit FROM PUBLIC;
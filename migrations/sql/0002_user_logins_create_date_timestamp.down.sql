@@ -0,0 +1 @@
+ALTER TABLE user_logins ALTER COLUMN create_date TYPE date USING create_date::date;
@@ -0,0 +1,2 @@
+-- create_date was a date, so the time of day of every login was lost. Existing logins keep midnight.
+ALTER TABLE user_logins ALTER COLUMN create_date TYPE timestamp USING create_date::timestamp;
@@ -0,0 +1,2 @@
+-- Fails rather than truncating when a stored version is longer than 10 characters.
+ALTER TABLE user_logins ALTER COLUMN app_version TYPE varchar(10);
@@ -0,0 +1,2 @@
+-- Versions such as 2.10.100-beta and masked versions do not fit in 10 characters.
+ALTER TABLE user_logins ALTER COLUMN app_version TYPE varchar(256);
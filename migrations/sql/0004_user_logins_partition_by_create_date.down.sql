@@ -0,0 +1,35 @@
+-- Moves the logins of every attached partition back into one table. Detached partitions are left as they are.
+
+ALTER TABLE user_logins RENAME TO user_logins_partitioned;
+ALTER INDEX user_logins_pkey RENAME TO user_logins_partitioned_pkey;
+
+ALTER SEQUENCE user_logins_id_seq OWNED BY NONE;
+
+CREATE TABLE user_logins(
+    id bigint PRIMARY KEY DEFAULT nextval('user_logins_id_seq'),
+    user_id varchar(128),
+    device_type varchar(32),
+    masked_ip varchar(256),
+    masked_device_id varchar(256),
+    locale varchar(32),
+    app_version varchar(256),
+    create_date timestamp,
+    per_user_key boolean NOT NULL DEFAULT false,
+    shredded boolean NOT NULL DEFAULT false,
+    ip_pseudonym inet
+);
+
+ALTER SEQUENCE user_logins_id_seq OWNED BY user_logins.id;
+
+INSERT INTO user_logins (id, user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date, per_user_key, shredded, ip_pseudonym)
+SELECT id, user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date, per_user_key, shredded, ip_pseudonym
+FROM user_logins_partitioned;
+
+-- Drops the partitions with it.
+DROP TABLE user_logins_partitioned;
+
+CREATE INDEX user_logins_create_date_id_idx ON user_logins (create_date DESC, id DESC);
+CREATE INDEX user_logins_user_id_idx ON user_logins (user_id);
+CREATE INDEX user_logins_masked_ip_idx ON user_logins (masked_ip);
+CREATE INDEX user_logins_masked_device_id_idx ON user_logins (masked_device_id);
+CREATE INDEX user_logins_ip_pseudonym_idx ON user_logins USING gist (ip_pseudonym inet_ops);
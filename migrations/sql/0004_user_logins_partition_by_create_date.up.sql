@@ -0,0 +1,66 @@
+-- Range-partitions user_logins by create_date into one partition per month, named user_logins_pYYYYMM, so queries on
+-- recent logins only scan recent partitions and expired months are dropped as a whole. The ETL creates the partitions
+-- of the next months and expires old ones, see the partitions package. Logins outside every partition land in
+-- user_logins_default.
+
+ALTER TABLE user_logins RENAME TO user_logins_unpartitioned;
+ALTER INDEX user_logins_pkey RENAME TO user_logins_unpartitioned_pkey;
+
+-- The ids continue from the same sequence, it must outlive the old table.
+ALTER SEQUENCE user_logins_id_seq OWNED BY NONE;
+
+-- The partition key must be part of the primary key and can not be null.
+CREATE TABLE user_logins(
+    id bigint NOT NULL DEFAULT nextval('user_logins_id_seq'),
+    user_id varchar(128),
+    device_type varchar(32),
+    masked_ip varchar(256),
+    masked_device_id varchar(256),
+    locale varchar(32),
+    app_version varchar(256),
+    create_date timestamp NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    per_user_key boolean NOT NULL DEFAULT false,
+    shredded boolean NOT NULL DEFAULT false,
+    ip_pseudonym inet,
+    PRIMARY KEY (id, create_date)
+) PARTITION BY RANGE (create_date);
+
+ALTER SEQUENCE user_logins_id_seq OWNED BY user_logins.id;
+
+CREATE TABLE user_logins_default PARTITION OF user_logins DEFAULT;
+
+-- One partition per month from the oldest login up to three months ahead, the default of partitions.premake_months.
+DO $$
+DECLARE
+    month timestamp := date_trunc('month', COALESCE((SELECT MIN(create_date) FROM user_logins_unpartitioned), NOW() AT TIME ZONE 'UTC'));
+BEGIN
+    WHILE month < date_trunc('month', NOW() AT TIME ZONE 'UTC') + interval '4 months' LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF user_logins FOR VALUES FROM (%L) TO (%L)',
+            'user_logins_p' || to_char(month, 'YYYYMM'), month, month + interval '1 month');
+        month := month + interval '1 month';
+    END LOOP;
+END
+$$;
+
+-- Logins without a date can not be placed in a month, they keep the epoch and land in the default partition.
+INSERT INTO user_logins (id, user_id, device_type, masked_ip, masked_device_id, locale, app_version, create_date, per_user_key, shredded, ip_pseudonym)
+SELECT id, user_id, device_type, masked_ip, masked_device_id, locale, app_version, COALESCE(create_date, 'epoch'), per_user_key, shredded, ip_pseudonym
+FROM user_logins_unpartitioned;
+
+DROP TABLE user_logins_unpartitioned;
+
+-- Indexes on the partitioned table are created on every partition, also on the ones created later.
+
+-- Supports keyset pagination in (create_date, id) order.
+CREATE INDEX user_logins_create_date_id_idx ON user_logins (create_date DESC, id DESC);
+
+-- Supports filtering logins by user.
+CREATE INDEX user_logins_user_id_idx ON user_logins (user_id);
+
+-- Supports the duplicate search, which numbers the logins of each masked IP and device ID by time, and looking up
+-- logins by a masked IP.
+CREATE INDEX user_logins_masked_ip_device_id_create_date_idx ON user_logins (masked_ip, masked_device_id, create_date, id);
+CREATE INDEX user_logins_masked_device_id_idx ON user_logins (masked_device_id);
+
+-- Supports containment queries on pseudonymous subnets.
+CREATE INDEX user_logins_ip_pseudonym_idx ON user_logins USING gist (ip_pseudonym inet_ops);
@@ -0,0 +1,7 @@
+-- Tokens without a shared ciphertext can no longer be detokenized and are deleted.
+REVOKE DELETE ON pii_vault FROM pii_vault_access;
+
+DROP TABLE IF EXISTS pii_vault_users;
+
+DELETE FROM pii_vault WHERE ciphertext IS NULL;
+ALTER TABLE pii_vault ALTER COLUMN ciphertext SET NOT NULL;
@@ -0,0 +1,22 @@
+-- A token stands for a value of every user that sent it, so the same input always gets the same token. What the token
+-- stands for is kept per user in pii_vault_users, encrypted with the key of that user's records, so erasing a user
+-- deletes their entries and leaves nothing readable. Entries of pii_vault created before keep their ciphertext,
+-- encrypted with the encryption key, new entries only hold the token and the fingerprint of the value.
+CREATE TABLE IF NOT EXISTS pii_vault_users(
+    token varchar(32) NOT NULL REFERENCES pii_vault (token) ON DELETE CASCADE,
+    user_id varchar(128) NOT NULL,
+    ciphertext varchar(256) NOT NULL,
+    create_date timestamp NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (token, user_id)
+);
+
+-- Supports deleting the entries of an erased user.
+CREATE INDEX IF NOT EXISTS pii_vault_users_user_id_idx ON pii_vault_users (user_id);
+
+ALTER TABLE pii_vault ALTER COLUMN ciphertext DROP NOT NULL;
+
+-- Like pii_vault, only roles granted pii_vault_access may read it. Erasing a user deletes from both tables.
+REVOKE ALL ON pii_vault_users FROM PUBLIC;
+
+GRANT SELECT, INSERT, DELETE ON pii_vault_users TO pii_vault_access;
+GRANT DELETE ON pii_vault TO pii_vault_access;
@@ -0,0 +1,10 @@
+-- The SQS message IDs of the logins inserted by the ETL. A message is delivered again when its batch was not
+-- acknowledged, e.g. when another sink failed, and is skipped when its ID is already here. user_logins itself can not
+-- hold a unique message ID, its unique indexes must include create_date, which differs on every delivery.
+CREATE TABLE IF NOT EXISTS loaded_messages(
+    message_id varchar(128) PRIMARY KEY,
+    loaded_at timestamp NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+-- Supports purging the IDs of messages SQS no longer delivers.
+CREATE INDEX IF NOT EXISTS loaded_messages_loaded_at_idx ON loaded_messages (loaded_at);
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// AuditEntry records a request that revealed PII: who asked, why, with which filters and whose PII was returned.
+type AuditEntry struct {
+	ID          int64               `json:"id"`
+	RequestID   string              `json:"request_id"`
+	Subject     string              `json:"subject"`
+	Role        string              `json:"role"`
+	AuthMethod  string              `json:"auth_method"`
+	Endpoint    string              `json:"endpoint"`
+	Reason      string              `json:"reason"`
+	Filters     map[string][]string `json:"filters"`
+	UserIDs     []string            `json:"user_ids"`
+	CreatedDate time.Time           `json:"created_date"`
+}
+
+// AuditFilter selects audit entries, empty fields match everything.
+type AuditFilter struct {
+	Limit     int
+	Cursor    *Cursor
+	Subject   string
+	UserID    string
+	RequestID string
+	From      *time.Time
+	To        *time.Time
+}
+
+// AuditPage is one page of audit entries, most recent first.
+type AuditPage struct {
+	Items      []AuditEntry `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	HasMore    bool         `json:"has_more"`
+}
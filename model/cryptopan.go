@@ -0,0 +1,84 @@
+package model
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"net"
+)
+
+// cryptoPAn implements the Crypto-PAn prefix-preserving anonymization scheme: two addresses sharing a prefix of n bits
+// are mapped to pseudonyms that share a prefix of exactly n bits.
+type cryptoPAn struct {
+	block cipher.Block
+	pad   []byte
+}
+
+// newCryptoPAn derives the 32 byte Crypto-PAn key from the secret.
+func newCryptoPAn(secret string) (*cryptoPAn, error) {
+	key := sha256.Sum256([]byte("crypto-pan:" + secret))
+
+	return newCryptoPAnKey(key)
+}
+
+// newCryptoPAnKey uses the first half of the key as the AES key and encrypts the second half to form the pad.
+func newCryptoPAnKey(key [32]byte) (*cryptoPAn, error) {
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	pad := make([]byte, aes.BlockSize)
+	block.Encrypt(pad, key[16:])
+
+	return &cryptoPAn{block: block, pad: pad}, nil
+}
+
+// PseudonymizeIP returns the prefix-preserving pseudonym of an IPv4 or IPv6 address.
+func PseudonymizeIP(ip string, secret string) (*string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, errors.New("invalid IP address " + ip)
+	}
+
+	if ip4 := addr.To4(); ip4 != nil {
+		addr = ip4
+	}
+
+	cp, err := newCryptoPAn(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	pseudonym := net.IP(cp.anonymize(addr)).String()
+
+	return &pseudonym, nil
+}
+
+// anonymize computes the pseudonym bit by bit: bit i of the result is bit i of the address flipped by the first bit of
+// the encryption of the address's first i bits padded with the pad.
+func (cp *cryptoPAn) anonymize(addr []byte) []byte {
+	result := make([]byte, len(addr))
+	input := make([]byte, aes.BlockSize)
+	output := make([]byte, aes.BlockSize)
+
+	for pos := 0; pos < len(addr)*8; pos++ {
+		copy(input, cp.pad)
+
+		// Copy the first pos bits of the address over the pad.
+		fullBytes, remBits := pos/8, pos%8
+		copy(input, addr[:fullBytes])
+		if remBits > 0 {
+			mask := byte(0xff << (8 - remBits))
+			input[fullBytes] = addr[fullBytes]&mask | cp.pad[fullBytes]&^mask
+		}
+
+		cp.block.Encrypt(output, input)
+
+		bit := (addr[pos/8] >> (7 - pos%8)) & 1
+		result[pos/8] |= (bit ^ output[0]>>7) << (7 - pos%8)
+	}
+
+	return result
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// DuplicateCluster is a group of logins sharing the same masked IP and masked device ID.
+type DuplicateCluster struct {
+	IP        *string    `json:"ip"`
+	DeviceID  *string    `json:"device_id"`
+	Count     int        `json:"count"`
+	UserIDs   []string   `json:"user_ids"`
+	FirstSeen time.Time  `json:"first_seen"`
+	LastSeen  time.Time  `json:"last_seen"`
+	Members   []Response `json:"members,omitempty"`
+
+	// MaskedIP and MaskedDeviceID are the stored key, they stay masked when IP and DeviceID are decrypted.
+	MaskedIP       string `json:"-"`
+	MaskedDeviceID string `json:"-"`
+}
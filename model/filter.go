@@ -1,8 +1,31 @@
 package model
 
+import "time"
+
 type Filter struct {
 	Limit           int
-	Page            int
+	Cursor          *Cursor
 	IsEncrypted     bool
 	GroupDuplicates bool
+	IPPrefix        string
+	Detokenize      bool
+	IP              string
+	DeviceID        string
+	UserID          string
+	DeviceType      string
+	Locale          string
+	AppVersion      string
+	AppVersionMin   *Version
+	AppVersionMax   *Version
+	From            *time.Time
+	To              *time.Time
+}
+
+// DuplicateFilter selects clusters of logins sharing a masked IP and device ID.
+type DuplicateFilter struct {
+	Filter
+	ClusterCursor  *ClusterCursor
+	MinSize        int
+	MultiUser      bool
+	IncludeMembers bool
 }
@@ -1,33 +1,30 @@
 package model
 
 import (
-	"encoding/xml"
+	"strconv"
 	"time"
 )
 
-type ReceiveMessageResponse struct {
-	XMLName              xml.Name             `xml:"ReceiveMessageResponse"`
-	ReceiveMessageResult ReceiveMessageResult `xml:"ReceiveMessageResult"`
-	ResponseMetadata     ResponseMetadata     `xml:"ResponseMetadata"`
-}
-
-type ResponseMetadata struct {
-	RequestId *string `xml:"RequestId"`
+// Message is a message received from SQS.
+type Message struct {
+	MessageId     *string
+	ReceiptHandle string
+	MD5OfBody     string
+	Body          string
+	Attributes    map[string]string
 }
 
-type ReceiveMessageResult struct {
-	Message []Message `xml:"Message"`
-}
+// SentTimestamp returns when the message was sent to the queue, or the zero time when SQS did not include it.
+func (msg *Message) SentTimestamp() time.Time {
+	if millis, err := strconv.ParseInt(msg.Attributes["SentTimestamp"], 10, 64); err == nil {
+		return time.UnixMilli(millis)
+	}
 
-type Message struct {
-	MessageId     *string `xml:"MessageId"`
-	ReceiptHandle string  `xml:"ReceiptHandle"`
-	MD5OfBody     string  `xml:"MD5OfBody"`
-	Body          string  `xml:"Body"`
+	return time.Time{}
 }
 
 type Response struct {
-	RequestId     *string   `json:"-"`
+	ID            int64     `json:"-"`
 	MessageId     *string   `json:"-"`
 	ReceiptHandle string    `json:"-"`
 	MD5OfBody     string    `json:"-"`
@@ -37,9 +34,16 @@ type Response struct {
 	IP            *string   `json:"ip"`
 	Locale        string    `json:"locale"`
 	DeviceID      *string   `json:"device_id"`
+	IPPseudonym   *string   `json:"ip_pseudonym,omitempty"`
 	CreatedDate   time.Time `json:"-"`
+	PerUserKey    bool      `json:"-"`
+	SentTimestamp time.Time `json:"-"`
+	Shredded      bool      `json:"shredded,omitempty"`
 }
 
+// ErasedMarker replaces the masked fields of records whose owner has been erased.
+const ErasedMarker = "[erased]"
+
 func (res *Response) Validate() bool {
 	if res.MessageId == nil || res.UserID == nil || res.IP == nil || res.DeviceID == nil || res.DeviceType == nil {
 		return false
@@ -48,33 +52,22 @@ func (res *Response) Validate() bool {
 	return true
 }
 
-// SetData sets the data fields of the Response struct based on the SQS message response.
-func (res *Response) SetData(requestId *string, msg *Message) {
-	res.RequestId = requestId
-	res.MessageId = msg.MessageId
-	res.ReceiptHandle = msg.ReceiptHandle
-	res.MD5OfBody = msg.MD5OfBody
-}
-
-// MaskBody encrypts the DeviceID and IP fields of the Response struct if they are not empty.
-func (res *Response) MaskBody(key string) error {
-	if res.DeviceID != nil {
-		encryptedDeviceId, err := encrypt(*res.DeviceID, key)
-		if err != nil {
-			return err
-		}
-
-		res.DeviceID = encryptedDeviceId
-	}
+// MarkErased replaces every field the policy masks reversibly with ErasedMarker.
+func (res *Response) MarkErased(policy MaskingPolicy) {
+	res.Shredded = true
 
-	if res.IP != nil {
-		encryptedIP, err := encrypt(*res.IP, key)
-		if err != nil {
-			return err
+	for _, field := range MaskedFields {
+		if policy.Method(field).Reversible() {
+			erased := ErasedMarker
+			res.setField(field, &erased)
 		}
-
-		res.IP = encryptedIP
 	}
+}
 
-	return nil
+// SetData sets the data fields of the Response struct based on the SQS message.
+func (res *Response) SetData(msg *Message) {
+	res.MessageId = msg.MessageId
+	res.ReceiptHandle = msg.ReceiptHandle
+	res.MD5OfBody = msg.MD5OfBody
+	res.SentTimestamp = msg.SentTimestamp()
 }
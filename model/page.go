@@ -0,0 +1,79 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Page is one page of records in a keyset paginated listing.
+type Page struct {
+	Items      []Response `json:"items"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	HasMore    bool       `json:"has_more"`
+}
+
+// Cursor points at the last record of a page, the next page starts right after it in (create_date, id) order.
+type Cursor struct {
+	CreatedDate time.Time `json:"t"`
+	ID          int64     `json:"id"`
+}
+
+// Encode returns the opaque string form of the cursor handed out to clients.
+func (c Cursor) Encode() string {
+	return encodeCursor(c)
+}
+
+// DecodeCursor parses a cursor previously returned by Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	var c Cursor
+	if err := decodeCursor(s, &c); err != nil || c.ID <= 0 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &c, nil
+}
+
+// ClusterPage is one page of duplicate clusters, largest clusters first.
+type ClusterPage struct {
+	Items      []DuplicateCluster `json:"items"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	HasMore    bool               `json:"has_more"`
+}
+
+// ClusterCursor points at the last cluster of a page in (size DESC, masked_ip, masked_device_id) order.
+type ClusterCursor struct {
+	Size     int    `json:"n"`
+	IP       string `json:"ip"`
+	DeviceID string `json:"d"`
+}
+
+// Encode returns the opaque string form of the cursor handed out to clients.
+func (c ClusterCursor) Encode() string {
+	return encodeCursor(c)
+}
+
+// DecodeClusterCursor parses a cursor previously returned by ClusterCursor.Encode.
+func DecodeClusterCursor(s string) (*ClusterCursor, error) {
+	var c ClusterCursor
+	if err := decodeCursor(s, &c); err != nil || c.Size <= 0 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &c, nil
+}
+
+func encodeCursor(c interface{}) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string, c interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, c)
+}
@@ -0,0 +1,359 @@
+package model
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+type MaskingMethod string
+
+const (
+	MethodEncrypt     MaskingMethod = "encrypt"
+	MethodHMAC        MaskingMethod = "hmac"
+	MethodTokenize    MaskingMethod = "tokenize"
+	MethodTruncate    MaskingMethod = "truncate"
+	MethodPartial     MaskingMethod = "partial"
+	MethodDrop        MaskingMethod = "drop"
+	MethodPassthrough MaskingMethod = "passthrough"
+)
+
+// MaskedFields lists the fields of a Response a masking policy can be applied to.
+var MaskedFields = []string{"user_id", "app_version", "device_type", "ip", "locale", "device_id"}
+
+// fieldWidths are the widths of the user_logins columns the fields are stored in.
+var fieldWidths = map[string]int{"user_id": 128, "app_version": 256, "device_type": 32, "ip": 256, "locale": 32, "device_id": 256}
+
+// methodWidths are the longest values stored by the methods that do not keep the length of the value: encrypt for a
+// value of 128 bytes, the hex HMAC-SHA256 and a vault token. The other methods store at most the value itself.
+var methodWidths = map[MaskingMethod]int{MethodEncrypt: 192, MethodHMAC: 64, MethodTokenize: 20}
+
+// FieldPolicy describes how a single field is masked.
+type FieldPolicy struct {
+	Method MaskingMethod `json:"method"`
+
+	// IPv4Prefix and IPv6Prefix are the prefix lengths kept by truncate for IP addresses, /24 and /48 by default.
+	IPv4Prefix int `json:"ipv4_prefix,omitempty"`
+	IPv6Prefix int `json:"ipv6_prefix,omitempty"`
+
+	// Length is the number of leading characters kept by truncate for other values, or trailing characters revealed by partial.
+	Length int `json:"length,omitempty"`
+}
+
+// MaskingPolicy assigns a masking method to each field, fields that are not listed are passed through.
+type MaskingPolicy struct {
+	Fields map[string]FieldPolicy `json:"fields"`
+
+	// IPPseudonym stores a prefix-preserving pseudonym of the IP next to the masked IP, so logins can be grouped by subnet.
+	IPPseudonym bool `json:"ip_pseudonym,omitempty"`
+}
+
+// Tokenizer swaps values for short tokens and back, the mapping is kept in a vault outside of the masked records.
+// A token is shared by every user that sent the value, which is kept per user encrypted with the key of their records.
+type Tokenizer interface {
+	Tokenize(ctx context.Context, userID, field, value, key string) (string, error)
+	Detokenize(ctx context.Context, userID, token, key string) (string, error)
+}
+
+// MaskingKeys holds the secrets used by the masking methods.
+type MaskingKeys struct {
+	// EncryptionKey is used by encrypt and tokenize, it is either the encryption secret or the user's own data key.
+	EncryptionKey string
+	// PseudonymKey is used by hmac, it is always the encryption secret so pseudonyms are stable across users.
+	PseudonymKey string
+	// Tokenizer is used by tokenize. When unmasking without a tokenizer, tokens are returned as they were stored.
+	Tokenizer Tokenizer
+}
+
+// DefaultMaskingPolicy encrypts the IP and device ID and stores every other field in clear.
+func DefaultMaskingPolicy() MaskingPolicy {
+	return MaskingPolicy{Fields: map[string]FieldPolicy{
+		"ip":        {Method: MethodEncrypt},
+		"device_id": {Method: MethodEncrypt},
+	}}
+}
+
+// LoadMaskingPolicy reads a masking policy from a JSON file, an empty path returns the default policy.
+func LoadMaskingPolicy(path string) (MaskingPolicy, error) {
+	if path == "" {
+		return DefaultMaskingPolicy(), nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return MaskingPolicy{}, err
+	}
+
+	var policy MaskingPolicy
+	if err = json.Unmarshal(content, &policy); err != nil {
+		return MaskingPolicy{}, errors.New(fmt.Sprintf("Error parsing masking policy %v: %v", path, err.Error()))
+	}
+
+	if err = policy.Validate(); err != nil {
+		return MaskingPolicy{}, err
+	}
+
+	return policy, nil
+}
+
+// Validate checks that the policy only refers to known fields and methods, and that every masked value fits its column.
+// user_id must be passed through since records, data keys and erasure are keyed by it.
+func (p MaskingPolicy) Validate() error {
+	var errs []string
+
+	for field, fp := range p.Fields {
+		if !isMaskedField(field) {
+			errs = append(errs, fmt.Sprintf("unknown field %q", field))
+		}
+
+		if field == "user_id" && fp.Method != "" && fp.Method != MethodPassthrough {
+			errs = append(errs, fmt.Sprintf("field %q must be passed through, got %q", field, fp.Method))
+		}
+
+		if width, ok := fieldWidths[field]; ok && methodWidths[fp.Method] > width {
+			errs = append(errs, fmt.Sprintf("field %q is stored in %d characters, %q stores up to %d", field, width, fp.Method, methodWidths[fp.Method]))
+		}
+
+		switch fp.Method {
+		case MethodEncrypt, MethodHMAC, MethodTokenize, MethodDrop, MethodPassthrough:
+		case MethodTruncate, MethodPartial:
+			if fp.IPv4Prefix < 0 || fp.IPv4Prefix > 32 || fp.IPv6Prefix < 0 || fp.IPv6Prefix > 128 || fp.Length < 0 {
+				errs = append(errs, fmt.Sprintf("field %q has an invalid prefix or length", field))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("field %q has unknown method %q", field, fp.Method))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid masking policy: " + strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// Method returns the masking method of the given field.
+func (p MaskingPolicy) Method(field string) MaskingMethod {
+	fp, ok := p.Fields[field]
+	if !ok || fp.Method == "" {
+		return MethodPassthrough
+	}
+
+	return fp.Method
+}
+
+// Reversible reports whether values masked with the method can be turned back into plaintext.
+func (m MaskingMethod) Reversible() bool {
+	return m == MethodEncrypt || m == MethodTokenize
+}
+
+// MaskBody masks every field of the Response struct according to the policy.
+func (res *Response) MaskBody(ctx context.Context, policy MaskingPolicy, keys MaskingKeys) error {
+	// The pseudonym is computed from the plaintext IP, values that are not IP addresses get no pseudonym. It is keyed with
+	// the pseudonym key so subnets can be grouped across users, so erasing a user clears it rather than shredding a key.
+	if policy.IPPseudonym && res.IP != nil {
+		res.IPPseudonym, _ = PseudonymizeIP(*res.IP, keys.PseudonymKey)
+	}
+
+	for _, field := range MaskedFields {
+		value := res.getField(field)
+		if value == nil {
+			continue
+		}
+
+		masked, err := policy.Fields[field].mask(ctx, derefOrEmpty(res.UserID), field, *value, keys)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error masking %v: %v", field, err.Error()))
+		}
+
+		res.setField(field, masked)
+	}
+
+	return nil
+}
+
+// MaskValue masks a single value of the field the same way MaskBody does, so it can be matched against stored records.
+// Tokenized fields are not handled here since searching must not create new tokens.
+func (p MaskingPolicy) MaskValue(field, value string, keys MaskingKeys) (*string, error) {
+	if p.Method(field) == MethodTokenize {
+		return nil, errors.New("tokenized values must be looked up in the vault")
+	}
+
+	// Tokenize is the only method reaching the vault, so no context is needed.
+	return p.Fields[field].mask(context.Background(), "", field, value, keys)
+}
+
+// UnmaskBody reverses every reversible field of the Response struct, the other fields are left as they were stored.
+func (res *Response) UnmaskBody(ctx context.Context, policy MaskingPolicy, keys MaskingKeys) error {
+	for _, field := range MaskedFields {
+		value := res.getField(field)
+		if value == nil {
+			continue
+		}
+
+		switch policy.Method(field) {
+		case MethodEncrypt:
+			plaintext, err := Decrypt(*value, keys.EncryptionKey)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Error decrypting %v: %v", field, err.Error()))
+			}
+
+			res.setField(field, plaintext)
+		case MethodTokenize:
+			if keys.Tokenizer == nil {
+				continue
+			}
+
+			var userID string
+			if res.UserID != nil {
+				userID = *res.UserID
+			}
+
+			plaintext, err := keys.Tokenizer.Detokenize(ctx, userID, *value, keys.EncryptionKey)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Error detokenizing %v: %v", field, err.Error()))
+			}
+
+			res.setField(field, &plaintext)
+		}
+	}
+
+	return nil
+}
+
+// mask applies the field policy to a single value of the user, a nil result means the value is dropped.
+func (fp FieldPolicy) mask(ctx context.Context, userID, field, value string, keys MaskingKeys) (*string, error) {
+	var masked string
+
+	switch fp.Method {
+	case MethodEncrypt:
+		return Encrypt(value, keys.EncryptionKey)
+	case MethodTokenize:
+		if keys.Tokenizer == nil {
+			return nil, errors.New("no token vault configured")
+		}
+
+		token, err := keys.Tokenizer.Tokenize(ctx, userID, field, value, keys.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		masked = token
+	case MethodHMAC:
+		mac := hmac.New(sha256.New, []byte(keys.PseudonymKey))
+		mac.Write([]byte(value))
+		masked = hex.EncodeToString(mac.Sum(nil))
+	case MethodTruncate:
+		masked = fp.truncate(value)
+	case MethodPartial:
+		masked = fp.partial(value)
+	case MethodDrop:
+		return nil, nil
+	default:
+		masked = value
+	}
+
+	return &masked, nil
+}
+
+// truncate keeps the network prefix of IP addresses and the leading characters of any other value.
+func (fp FieldPolicy) truncate(value string) string {
+	if ip := net.ParseIP(value); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(orDefault(fp.IPv4Prefix, 24), 32)).String()
+		}
+
+		return ip.Mask(net.CIDRMask(orDefault(fp.IPv6Prefix, 48), 128)).String()
+	}
+
+	if fp.Length > 0 && fp.Length < len(value) {
+		return value[:fp.Length]
+	}
+
+	return value
+}
+
+// partial reveals the last characters of the value and replaces the rest with '*'.
+func (fp FieldPolicy) partial(value string) string {
+	reveal := orDefault(fp.Length, 4)
+	if reveal >= len(value) {
+		return value
+	}
+
+	return strings.Repeat("*", len(value)-reveal) + value[len(value)-reveal:]
+}
+
+// getField returns a pointer to the value of the named field, or nil if the field is empty.
+func (res *Response) getField(field string) *string {
+	switch field {
+	case "user_id":
+		return res.UserID
+	case "device_type":
+		return res.DeviceType
+	case "ip":
+		return res.IP
+	case "device_id":
+		return res.DeviceID
+	case "app_version":
+		if res.AppVersion != "" {
+			return &res.AppVersion
+		}
+	case "locale":
+		if res.Locale != "" {
+			return &res.Locale
+		}
+	}
+
+	return nil
+}
+
+// setField sets the value of the named field, nil clears it.
+func (res *Response) setField(field string, value *string) {
+	switch field {
+	case "user_id":
+		res.UserID = value
+	case "device_type":
+		res.DeviceType = value
+	case "ip":
+		res.IP = value
+	case "device_id":
+		res.DeviceID = value
+	case "app_version":
+		res.AppVersion = derefOrEmpty(value)
+	case "locale":
+		res.Locale = derefOrEmpty(value)
+	}
+}
+
+func isMaskedField(field string) bool {
+	for _, f := range MaskedFields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+func orDefault(value, def int) int {
+	if value == 0 {
+		return def
+	}
+
+	return value
+}
+
+func derefOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// Unknown groups app versions that can not be parsed as semver and logins without a device type.
+const Unknown = "unknown"
+
+// AppVersionCount is the number of logins and distinct users of a major.minor.patch app version, or Unknown, on a device
+// type. A nil AppVersion or DeviceType means the count is a total over all of them.
+type AppVersionCount struct {
+	AppVersion *string
+	DeviceType *string
+	Logins     int
+	Users      int
+}
+
+// AppVersionReport shows which app versions are live on which device types in a time window.
+// Users logging in with several versions count towards each of them, so shares may add up to more than 1.
+type AppVersionReport struct {
+	From                time.Time         `json:"from"`
+	To                  time.Time         `json:"to"`
+	MinSupportedVersion string            `json:"min_supported_version,omitempty"`
+	Logins              int               `json:"logins"`
+	Users               int               `json:"users"`
+	DeviceTypes         []DeviceTypeShare `json:"device_types"`
+	Versions            []AppVersionShare `json:"versions"`
+}
+
+// AppVersionShare is the adoption of one major.minor.patch version.
+type AppVersionShare struct {
+	Version      string            `json:"version"`
+	Logins       int               `json:"logins"`
+	Users        int               `json:"users"`
+	UserShare    float64           `json:"user_share"`
+	Unsupported  bool              `json:"unsupported"`
+	DeviceTypes  []DeviceTypeShare `json:"device_types"`
+	parsed       Version
+	deviceByName map[string]*DeviceTypeShare
+}
+
+// DeviceTypeShare is the number of logins and users on a device type. UserShare is relative to all users in the report,
+// or to the users of the device type within a version.
+type DeviceTypeShare struct {
+	DeviceType string  `json:"device_type"`
+	Logins     int     `json:"logins"`
+	Users      int     `json:"users"`
+	UserShare  float64 `json:"user_share"`
+}
+
+// NewAppVersionReport builds the report from counts grouped by normalized app version and device type, plus per version,
+// per device type and overall totals. Distinct users can not be summed, so every total is taken from its own count.
+// Versions below minSupported are flagged.
+func NewAppVersionReport(from, to time.Time, counts []AppVersionCount, minSupported *Version) AppVersionReport {
+	report := AppVersionReport{From: from, To: to, DeviceTypes: []DeviceTypeShare{}, Versions: []AppVersionShare{}}
+	if minSupported != nil {
+		report.MinSupportedVersion = minSupported.String()
+	}
+
+	deviceUsers := make(map[string]int)
+	versions := make(map[string]*AppVersionShare)
+
+	// Totals first, shares are relative to them.
+	for _, c := range counts {
+		switch {
+		case c.AppVersion == nil && c.DeviceType == nil:
+			report.Logins, report.Users = c.Logins, c.Users
+		case c.AppVersion == nil:
+			deviceUsers[deviceTypeName(c.DeviceType)] = c.Users
+			report.DeviceTypes = append(report.DeviceTypes, DeviceTypeShare{DeviceType: deviceTypeName(c.DeviceType), Logins: c.Logins, Users: c.Users})
+		}
+	}
+
+	for _, c := range counts {
+		if c.AppVersion == nil {
+			continue
+		}
+
+		name := *c.AppVersion
+
+		share, ok := versions[name]
+		if !ok {
+			share = &AppVersionShare{Version: name, deviceByName: make(map[string]*DeviceTypeShare)}
+			if v, err := ParseVersion(name); err == nil && name != Unknown {
+				share.parsed = v
+				share.Unsupported = minSupported != nil && v.Compare(*minSupported) < 0
+			}
+
+			versions[name] = share
+		}
+
+		if c.DeviceType == nil {
+			share.Logins, share.Users = c.Logins, c.Users
+			continue
+		}
+
+		deviceType := deviceTypeName(c.DeviceType)
+		share.deviceByName[deviceType] = &DeviceTypeShare{DeviceType: deviceType, Logins: c.Logins, Users: c.Users}
+	}
+
+	for _, share := range versions {
+		share.UserShare = ratio(share.Users, report.Users)
+
+		for _, device := range share.deviceByName {
+			device.UserShare = ratio(device.Users, deviceUsers[device.DeviceType])
+			share.DeviceTypes = append(share.DeviceTypes, *device)
+		}
+
+		sort.Slice(share.DeviceTypes, func(i, j int) bool { return share.DeviceTypes[i].DeviceType < share.DeviceTypes[j].DeviceType })
+		report.Versions = append(report.Versions, *share)
+	}
+
+	for i := range report.DeviceTypes {
+		report.DeviceTypes[i].UserShare = ratio(report.DeviceTypes[i].Users, report.Users)
+	}
+
+	sort.Slice(report.DeviceTypes, func(i, j int) bool { return report.DeviceTypes[i].DeviceType < report.DeviceTypes[j].DeviceType })
+
+	// Newest versions first, unknown versions last.
+	sort.Slice(report.Versions, func(i, j int) bool {
+		vi, vj := report.Versions[i], report.Versions[j]
+		if (vi.Version == Unknown) != (vj.Version == Unknown) {
+			return vj.Version == Unknown
+		}
+
+		return vi.parsed.Compare(vj.parsed) > 0
+	})
+
+	return report
+}
+
+func deviceTypeName(deviceType *string) string {
+	if deviceType == nil || *deviceType == "" {
+		return Unknown
+	}
+
+	return *deviceType
+}
+
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(part) / float64(total)
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a major.minor.patch application version, missing parts are zero.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses versions such as "2.3.0", "2.3" or "v2", pre-release and build suffixes are ignored.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+
+	trimmed := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+
+	parts := strings.Split(trimmed, ".")
+	if trimmed == "" || len(parts) > 3 {
+		return v, errors.New(fmt.Sprintf("invalid version %q", s))
+	}
+
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, errors.New(fmt.Sprintf("invalid version %q", s))
+		}
+
+		nums[i] = n
+	}
+
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or higher than other.
+func (v Version) Compare(other Version) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d < 0 {
+			return -1
+		}
+
+		if d > 0 {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Parts returns the version as a [major, minor, patch] slice.
+func (v Version) Parts() []int64 {
+	return []int64{int64(v.Major), int64(v.Minor), int64(v.Patch)}
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// StatsFilter selects the time buckets of login volume statistics.
+type StatsFilter struct {
+	Bucket  string
+	GroupBy string
+	From    time.Time
+	To      time.Time
+}
+
+// LoginStat is the login volume of one time bucket, optionally of one group within it.
+type LoginStat struct {
+	Bucket        time.Time `json:"bucket"`
+	Group         *string   `json:"group,omitempty"`
+	Logins        int       `json:"logins"`
+	DistinctUsers int       `json:"distinct_users"`
+}
+
+// LoginStats is the login volume time series of a time range.
+type LoginStats struct {
+	Bucket  string      `json:"bucket"`
+	GroupBy string      `json:"group_by,omitempty"`
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Items   []LoginStat `json:"items"`
+}
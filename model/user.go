@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// UserLoginHistory is the login timeline of a single user with a summary over the whole time window.
+type UserLoginHistory struct {
+	UserID  string           `json:"user_id"`
+	Summary UserLoginSummary `json:"summary"`
+	Logins  *Page            `json:"logins"`
+}
+
+// UserLoginSummary describes all logins of a user in a time window. Distinct IPs and devices are masked or unmasked
+// like the listed logins, values of erased records are left out.
+type UserLoginSummary struct {
+	TotalLogins     int        `json:"total_logins"`
+	FirstLogin      *time.Time `json:"first_login"`
+	LastLogin       *time.Time `json:"last_login"`
+	DistinctDevices []string   `json:"distinct_devices"`
+	DistinctIPs     []string   `json:"distinct_ips"`
+	DeviceTypes     []string   `json:"device_types"`
+	AppVersions     []string   `json:"app_versions"`
+}
@@ -5,10 +5,14 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/base64"
+	"errors"
 )
 
-// encrypt encrypts plaintext using AES encryption with the provided key.
-func encrypt(plaintext string, key string) (*string, error) {
+// ErrDecrypt is returned when a ciphertext does not decrypt with the key, e.g. it was encrypted with another key.
+var ErrDecrypt = errors.New("ciphertext does not decrypt with the key")
+
+// Encrypt encrypts plaintext using AES encryption with the provided key.
+func Encrypt(plaintext string, key string) (*string, error) {
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
 		return nil, err
@@ -43,16 +47,36 @@ func Decrypt(ciphertextStr string, key string) (*string, error) {
 		return nil, err
 	}
 
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrDecrypt
+	}
+
 	decrypted := make([]byte, len(ciphertext))
 
 	mode := cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize))
 	mode.CryptBlocks(decrypted, ciphertext)
 
-	// Remove padding
+	// Remove padding, a wrong key or a corrupted ciphertext leaves random bytes where the padding should be.
 	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(decrypted) {
+		return nil, ErrDecrypt
+	}
+
+	for _, b := range decrypted[len(decrypted)-padding:] {
+		if int(b) != padding {
+			return nil, ErrDecrypt
+		}
+	}
+
 	decrypted = decrypted[:len(decrypted)-padding]
 
 	plainText := string(decrypted)
 
 	return &plainText, nil
 }
+
+// CheckKey reports whether the key can be used to encrypt, i.e. it is a valid AES-128, AES-192 or AES-256 key.
+func CheckKey(key string) error {
+	_, err := Encrypt("check", key)
+	return err
+}
@@ -0,0 +1,12 @@
+package partitions
+
+import "context"
+
+// Maintainer keeps the monthly partitions of user_logins ahead of the current month and within the retention.
+type Maintainer interface {
+	// Maintain creates the missing partitions and drops or detaches the expired ones. A dry run only reports them.
+	// A failed change does not stop the others, the error lists the failed ones.
+	Maintain(ctx context.Context, dryRun bool) (Report, error)
+	// Run maintains the partitions every configured interval until ctx is done.
+	Run(ctx context.Context)
+}
@@ -0,0 +1,364 @@
+// Package partitions maintains the monthly partitions of user_logins that migration 0004 creates. Each partition is
+// named user_logins_pYYYYMM and holds the logins of that month, from its first day up to the first day of the next.
+package partitions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/config"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	table            = "user_logins"
+	prefix           = "user_logins_p"
+	defaultPartition = "user_logins_default"
+
+	// lockKey is the Postgres advisory lock held while maintaining, so one ETL at a time changes the partitions.
+	lockKey = 7_160_042_048
+
+	// lockTimeout bounds how long a change waits for the queries on user_logins, instead of blocking new ones.
+	lockTimeout = "5s"
+)
+
+// ErrLocked is returned when another process is maintaining the partitions.
+var ErrLocked = errors.New("the partitions are being maintained by another process")
+
+// Actions of a change.
+const (
+	ActionCreate = "create"
+	ActionDrop   = config.ExpiredDrop
+	ActionDetach = config.ExpiredDetach
+)
+
+// Change is a partition created, dropped or detached.
+type Change struct {
+	Action    string
+	Partition string
+	From      time.Time
+	To        time.Time
+	// Rows is the number of logins in an expired partition as estimated by Postgres, 0 when it was never analyzed.
+	Rows int64
+}
+
+// Report lists the changes of a maintenance run, leaving out the failed ones, and the logins outside every monthly
+// partition.
+type Report struct {
+	DryRun      bool
+	Changes     []Change
+	DefaultRows int64
+}
+
+type maintainer struct {
+	logger   *log.CustomLogger
+	dbConn   *sql.DB
+	settings config.Partitions
+}
+
+// New returns a Maintainer of the partitions of user_logins with the given schedule and retention.
+func New(logger *log.CustomLogger, dbConn *sql.DB, settings config.Partitions) Maintainer {
+	return &maintainer{
+		logger:   logger,
+		dbConn:   dbConn,
+		settings: settings,
+	}
+}
+
+func (m *maintainer) Maintain(ctx context.Context, dryRun bool) (Report, error) {
+	report := Report{DryRun: dryRun}
+
+	conn, err := m.dbConn.Conn(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	defer conn.Close()
+
+	// A dry run changes nothing, it does not wait for the lock either.
+	if !dryRun {
+		var locked bool
+		if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&locked); err != nil {
+			return report, errors.New(fmt.Sprintf("Error taking the partition lock: %v", err.Error()))
+		}
+
+		if !locked {
+			return report, ErrLocked
+		}
+
+		// The lock belongs to the session, it is released even if ctx is already canceled.
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+	}
+
+	existing, err := attached(ctx, conn)
+	if err != nil {
+		return report, err
+	}
+
+	report.Changes = m.plan(time.Now().UTC(), existing)
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", pq.QuoteIdentifier(defaultPartition))
+	if err = conn.QueryRowContext(ctx, query).Scan(&report.DefaultRows); err != nil {
+		return report, errors.New(fmt.Sprintf("Error counting the logins in %v: %v", defaultPartition, err.Error()))
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	// A failed change is retried on the next run, it does not hold back the other changes.
+	planned := report.Changes
+	report.Changes = nil
+
+	var failures []string
+	for _, change := range planned {
+		if err = m.apply(ctx, conn, change); err != nil {
+			lm := log.Message{Level: "ERROR", ErrorMessage: err.Error()}
+			m.logger.Log(&lm)
+
+			failures = append(failures, change.Partition)
+			continue
+		}
+
+		report.Changes = append(report.Changes, change)
+	}
+
+	if len(failures) > 0 {
+		return report, errors.New(fmt.Sprintf("Error maintaining the partitions, %d of %d changes failed: %v", len(failures), len(planned), strings.Join(failures, ", ")))
+	}
+
+	return report, nil
+}
+
+func (m *maintainer) Run(ctx context.Context) {
+	if m.settings.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce maintains the partitions and logs the outcome, the changes were logged as they were made.
+func (m *maintainer) runOnce(ctx context.Context) {
+	report, err := m.Maintain(ctx, m.settings.DryRun)
+
+	switch {
+	case errors.Is(err, ErrLocked):
+		lm := log.Message{Level: "INFO", Msg: "Skipping partition maintenance, another process is maintaining the partitions."}
+		m.logger.Log(&lm)
+	case err != nil:
+		lm := log.Message{Level: "ERROR", ErrorMessage: fmt.Sprintf("Partition maintenance made %d changes with error %v", len(report.Changes), err.Error())}
+		m.logger.Log(&lm)
+	case report.DryRun:
+		for _, change := range report.Changes {
+			lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Dry run: would %v", change)}
+			m.logger.Log(&lm)
+		}
+
+		lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Dry run of partition maintenance found %d changes.", len(report.Changes))}
+		m.logger.Log(&lm)
+	}
+
+	if err == nil && report.DefaultRows > 0 {
+		lm := log.Message{Level: "WARN", ErrorMessage: fmt.Sprintf("%d logins are in %v outside every monthly partition and are never expired", report.DefaultRows, defaultPartition)}
+		m.logger.Log(&lm)
+	}
+}
+
+// plan returns the expired partitions, oldest first, followed by the missing ones from the current month on.
+// A partition is expired once its whole month is older than the retention.
+func (m *maintainer) plan(now time.Time, existing map[time.Time]int64) []Change {
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var changes []Change
+
+	if m.settings.RetentionMonths > 0 {
+		oldest := current.AddDate(0, -m.settings.RetentionMonths, 0)
+
+		months := make([]time.Time, 0, len(existing))
+		for month := range existing {
+			if month.Before(oldest) {
+				months = append(months, month)
+			}
+		}
+
+		sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+
+		for _, month := range months {
+			changes = append(changes, change(m.settings.ExpiredAction, month, existing[month]))
+		}
+	}
+
+	for i := 0; i <= m.settings.PremakeMonths; i++ {
+		month := current.AddDate(0, i, 0)
+		if _, ok := existing[month]; !ok {
+			changes = append(changes, change(ActionCreate, month, 0))
+		}
+	}
+
+	return changes
+}
+
+// apply makes the change in its own transaction, giving up when user_logins stays locked longer than lockTimeout.
+func (m *maintainer) apply(ctx context.Context, conn *sql.Conn, change Change) error {
+	var statement string
+
+	switch change.Action {
+	case ActionCreate:
+		statement = fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+			pq.QuoteIdentifier(change.Partition), table, change.From.Format(time.DateOnly), change.To.Format(time.DateOnly))
+	case ActionDrop:
+		statement = fmt.Sprintf("DROP TABLE %s", pq.QuoteIdentifier(change.Partition))
+	case ActionDetach:
+		statement = fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", table, pq.QuoteIdentifier(change.Partition))
+	default:
+		return errors.New(fmt.Sprintf("unknown partition action %q", change.Action))
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL lock_timeout = '%s'", lockTimeout)); err != nil {
+		return err
+	}
+
+	var moved int64
+	if change.Action == ActionCreate {
+		moved, err = createFromDefault(ctx, tx, change, statement)
+	} else {
+		_, err = tx.ExecContext(ctx, statement)
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error trying to %v: %v", change, err.Error()))
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	lm := log.Message{Level: "INFO", Msg: fmt.Sprintf("Partition maintenance: %v", change)}
+	if moved > 0 {
+		lm.Msg += fmt.Sprintf(", moved %d logins from %v", moved, defaultPartition)
+	}
+
+	m.logger.Log(&lm)
+
+	return nil
+}
+
+// createFromDefault creates the partition with the given statement and returns the number of logins it took over.
+// Postgres refuses to create a partition while the default one holds logins of its month, e.g. logins that arrived
+// before the partition was made, so the default partition is detached, its logins of the month are moved to the new
+// partition and it is attached again.
+func createFromDefault(ctx context.Context, tx *sql.Tx, change Change, statement string) (int64, error) {
+	var found bool
+
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE create_date >= $1 AND create_date < $2)", pq.QuoteIdentifier(defaultPartition))
+	if err := tx.QueryRowContext(ctx, query, change.From.Format(time.DateOnly), change.To.Format(time.DateOnly)).Scan(&found); err != nil {
+		return 0, err
+	}
+
+	if !found {
+		_, err := tx.ExecContext(ctx, statement)
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", table, pq.QuoteIdentifier(defaultPartition))); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		return 0, err
+	}
+
+	// The default partition has the columns of user_logins in the same order, inserting into user_logins routes the
+	// logins to the new partition.
+	move := fmt.Sprintf("WITH moved AS (DELETE FROM %s WHERE create_date >= $1 AND create_date < $2 RETURNING *) INSERT INTO %s SELECT * FROM moved",
+		pq.QuoteIdentifier(defaultPartition), table)
+
+	res, err := tx.ExecContext(ctx, move, change.From.Format(time.DateOnly), change.To.Format(time.DateOnly))
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s DEFAULT", table, pq.QuoteIdentifier(defaultPartition))); err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// String describes the change, e.g. "create user_logins_p202406 for [2024-06-01, 2024-07-01)".
+func (c Change) String() string {
+	description := fmt.Sprintf("%v %v for [%v, %v)", c.Action, c.Partition, c.From.Format(time.DateOnly), c.To.Format(time.DateOnly))
+	if c.Action != ActionCreate {
+		description += fmt.Sprintf(" with about %d logins", c.Rows)
+	}
+
+	return description
+}
+
+func change(action string, month time.Time, rows int64) Change {
+	return Change{
+		Action:    action,
+		Partition: prefix + month.Format("200601"),
+		From:      month,
+		To:        month.AddDate(0, 1, 0),
+		Rows:      rows,
+	}
+}
+
+// attached returns the first day of the month of every attached monthly partition, with its estimated rows.
+// Partitions not named user_logins_pYYYYMM, such as the default one, are left alone.
+func attached(ctx context.Context, conn *sql.Conn) (map[time.Time]int64, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT c.relname, GREATEST(c.reltuples, 0)::bigint FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass", table)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error listing the partitions of %v: %v", table, err.Error()))
+	}
+
+	defer rows.Close()
+
+	existing := make(map[time.Time]int64)
+	for rows.Next() {
+		var name string
+		var estimate int64
+
+		if err = rows.Scan(&name, &estimate); err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		month, err := time.Parse("200601", strings.TrimPrefix(name, prefix))
+		if err != nil {
+			continue
+		}
+
+		existing[month] = estimate
+	}
+
+	return existing, rows.Err()
+}
@@ -5,21 +5,31 @@ import os
 
 
 # Define a function to fetch data from the API
-def fetch_data(limit, page, isEncrypted, groupDuplicates):
+def fetch_data(limit, cursor, isEncrypted, groupDuplicates, reason):
     API_SERVER_ENDPOINT = os.getenv("API_SERVER_ENDPOINT", "http://localhost:8080/login-data")
-    # Replace 'YOUR_API_ENDPOINT' with your actual API endpoint
-    response = requests.get('{0}?limit={1}&page={2}&isEncrypted={3}&groupDuplicates={4}'.format(API_SERVER_ENDPOINT, limit, page, isEncrypted, groupDuplicates))
+    params = {'limit': limit, 'isEncrypted': isEncrypted, 'groupDuplicates': groupDuplicates}
+    if cursor:
+        params['cursor'] = cursor
+    # Decrypted data is only returned with a reason, which is kept in the audit log.
+    if not isEncrypted:
+        params['reason'] = reason
+
+    # API_KEY authenticates the UI, showing decrypted data needs a key with the pii-reader role.
+    headers = {'X-API-Key': os.getenv("API_KEY")} if os.getenv("API_KEY") else {}
+
+    response = requests.get(API_SERVER_ENDPOINT, params=params, headers=headers)
     if response.status_code == 200:
         return response.json()
     else:
         st.error(f"Error fetching data: {response.status_code}")
-        return []
+        return {'items': [], 'has_more': False}
 
 
 # Define a function to load data and manage masking
-def load_data(limit, page, isEncrypted, groupDuplicates):
-    data = fetch_data(limit, page, isEncrypted, groupDuplicates)
-    df = pd.DataFrame(data)
+def load_data(limit, cursor, isEncrypted, groupDuplicates, reason):
+    data = fetch_data(limit, cursor, isEncrypted, groupDuplicates, reason)
+    st.session_state['next_cursor'] = data.get('next_cursor') if data.get('has_more') else None
+    df = pd.DataFrame(data.get('items', []))
     return df
 
 
@@ -27,8 +37,12 @@ def init_session():
     if 'limit' not in st.session_state:
         st.session_state['limit'] = 5
 
-    if 'page' not in st.session_state:
-        st.session_state['page'] = 1
+    # Cursor of the page being shown, None is the first page.
+    if 'cursor' not in st.session_state:
+        st.session_state['cursor'] = None
+
+    if 'next_cursor' not in st.session_state:
+        st.session_state['next_cursor'] = None
 
     if 'isEncrypted' not in st.session_state:
         st.session_state['isEncrypted'] = True
@@ -49,9 +63,11 @@ def main():
         st.write("Records limit: ",  st.session_state['limit'])
 
     with col2:
-        page = st.number_input("Select the page", value=st.session_state['page'])
-        st.session_state['page'] = page
-        st.write("Page ",  st.session_state['page'])
+        st.write("\n\n\n")
+        if st.button("First page"):
+            st.session_state['cursor'] = None
+        if st.button("Next page", disabled=st.session_state['next_cursor'] is None):
+            st.session_state['cursor'] = st.session_state['next_cursor']
 
     with col3:
         st.write("\n\n\n")
@@ -72,10 +88,14 @@ def main():
 
         st.write("Group Duplicates: ", st.session_state['groupDuplicates'])
 
+    reason = ""
+    if not st.session_state['isEncrypted']:
+        reason = st.text_input("Reason for viewing decrypted data")
+
     if st.button("Fetch Data"):
         # Load data
         data_load_state = st.text('Loading data...')
-        df = load_data(st.session_state['limit'], st.session_state['page'], st.session_state['isEncrypted'], st.session_state['groupDuplicates'])
+        df = load_data(st.session_state['limit'], st.session_state['cursor'], st.session_state['isEncrypted'], st.session_state['groupDuplicates'], reason)
         data_load_state.text('')
 
         st.dataframe(data=df)
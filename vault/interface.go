@@ -0,0 +1,9 @@
+package vault
+
+import "context"
+
+type Vault interface {
+	Tokenize(ctx context.Context, userID, field, value, key string) (string, error)
+	Detokenize(ctx context.Context, userID, token, key string) (string, error)
+	Lookup(ctx context.Context, field, value string) (string, error)
+}
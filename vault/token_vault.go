@@ -0,0 +1,158 @@
+package vault
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/log"
+	"github.com/shivasaicharanruthala/dataops-takehome-2/model"
+	"strings"
+)
+
+// tokenPrefix marks a value as a vault token.
+const tokenPrefix = "tok_"
+
+// ErrUnknownToken is returned when a token has no entry in the vault.
+var ErrUnknownToken = errors.New("unknown token")
+
+type tokenVault struct {
+	dbConn        *sql.DB
+	encryptionKey string
+}
+
+// New returns a Vault that keeps the tokens in the pii_vault table and what they stand for per user in the
+// pii_vault_users table. The encryption key fingerprints the values, and decrypts the entries created before entries
+// were kept per user.
+func New(dbConn *sql.DB, encryptionKey string) Vault {
+	return &tokenVault{
+		dbConn:        dbConn,
+		encryptionKey: encryptionKey,
+	}
+}
+
+// Tokenize returns the token of the value, creating one if the value has not been seen before. Values are looked up
+// by a fingerprint keyed with the encryption key, so the same input always gets the same token, whichever user sent it.
+// The value is kept for the user encrypted with key, the key of the user's records, so erasing the user leaves
+// nothing readable.
+func (tv *tokenVault) Tokenize(ctx context.Context, userID, field, value, key string) (string, error) {
+	fingerprint := fingerprint(tv.encryptionKey, field, value)
+
+	ciphertext, err := model.Encrypt(value, key)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := tv.token(ctx, field, fingerprint)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tv.dbConn.ExecContext(ctx, log.Traced(ctx, "INSERT INTO pii_vault_users (token, user_id, ciphertext) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"), token, userID, *ciphertext)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error storing token: %v", err.Error()))
+	}
+
+	return token, nil
+}
+
+// token returns the token of the fingerprint, creating one if there is none.
+func (tv *tokenVault) token(ctx context.Context, field, fingerprint string) (string, error) {
+	// A new token can only collide with an existing one by chance, in which case another one is drawn.
+	for attempt := 0; attempt < 3; attempt++ {
+		token, err := newToken()
+		if err != nil {
+			return "", err
+		}
+
+		_, err = tv.dbConn.ExecContext(ctx, log.Traced(ctx, "INSERT INTO pii_vault (token, field, fingerprint) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"), token, field, fingerprint)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Error storing token: %v", err.Error()))
+		}
+
+		var stored string
+		err = tv.dbConn.QueryRowContext(ctx, log.Traced(ctx, "SELECT token FROM pii_vault WHERE fingerprint = $1"), fingerprint).Scan(&stored)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Error fetching token: %v", err.Error()))
+		}
+
+		return stored, nil
+	}
+
+	return "", errors.New("Error storing token: too many token collisions")
+}
+
+// Detokenize returns the plaintext value of the user's token, decrypted with key, the key of the record holding the
+// token. It returns ErrUnknownToken when the token has no entry of the user, e.g. the user has been erased.
+func (tv *tokenVault) Detokenize(ctx context.Context, userID, token, key string) (string, error) {
+	var userCiphertext, sharedCiphertext sql.NullString
+
+	err := tv.dbConn.QueryRowContext(ctx, log.Traced(ctx, "SELECT u.ciphertext, v.ciphertext FROM pii_vault v LEFT JOIN pii_vault_users u ON u.token = v.token AND u.user_id = $2 WHERE v.token = $1"), token, userID).Scan(&userCiphertext, &sharedCiphertext)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnknownToken
+	}
+
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error fetching token: %v", err.Error()))
+	}
+
+	ciphertext := userCiphertext.String
+	switch {
+	case userCiphertext.Valid:
+	case sharedCiphertext.Valid:
+		// Entries created before entries were kept per user are encrypted with the encryption key.
+		ciphertext, key = sharedCiphertext.String, tv.encryptionKey
+	default:
+		return "", ErrUnknownToken
+	}
+
+	value, err := model.Decrypt(ciphertext, key)
+	if err != nil {
+		return "", err
+	}
+
+	return *value, nil
+}
+
+// Lookup returns the existing token of the value without creating one, or ErrUnknownToken if it has none.
+func (tv *tokenVault) Lookup(ctx context.Context, field, value string) (string, error) {
+	var token string
+
+	err := tv.dbConn.QueryRowContext(ctx, log.Traced(ctx, "SELECT token FROM pii_vault WHERE fingerprint = $1"), fingerprint(tv.encryptionKey, field, value)).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnknownToken
+	}
+
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error fetching token: %v", err.Error()))
+	}
+
+	return token, nil
+}
+
+// fingerprint returns the hash identifying a value of a field in the vault.
+func fingerprint(key, field, value string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(field + ":" + value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newToken returns a random token of 16 base32 characters after the prefix.
+func newToken() (string, error) {
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return tokenPrefix + strings.ToLower(base32.StdEncoding.EncodeToString(random)), nil
+}